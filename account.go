@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// Accounts users ask to delete are kept for a grace period, during which
+// they can still sign in and take the request back, and are purged after
+// it. Purging runs at startup and then every purgeInterval.
+const (
+	defaultDeletionGrace = 30 * 24 * time.Hour
+	purgeInterval        = time.Hour
+)
+
+// What happens to the chirps of a deleted user: they are deleted along
+// with the account, or kept with no author.
+const (
+	chirpDeletionDelete    = "delete"
+	chirpDeletionAnonymize = "anonymize"
+)
+
+// accountDeletionFromEnv reads ACCOUNT_DELETION_GRACE, a duration such as
+// 720h where 0 deletes accounts straight away, and CHIRP_DELETION_POLICY,
+// "delete" (the default) or "anonymize".
+func accountDeletionFromEnv() (time.Duration, string, error) {
+	grace, err := durationFromEnv("ACCOUNT_DELETION_GRACE", defaultDeletionGrace)
+	if err != nil {
+		return 0, "", err
+	}
+	policy := envOr("CHIRP_DELETION_POLICY", chirpDeletionDelete)
+	if policy != chirpDeletionDelete && policy != chirpDeletionAnonymize {
+		return 0, "", fmt.Errorf("Unknown CHIRP_DELETION_POLICY: %s", policy)
+	}
+	return grace, policy, nil
+}
+
+// revokeAllCredentials signs the user out everywhere and revokes their API
+// keys, so nothing issued before keeps working for long.
+func revokeAllCredentials(tx database.Tx, userID int) error {
+	sessions, err := activeSessions(tx, userID)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		err := tx.RevokeRefreshTokenFamily(s.ID)
+		if err != nil {
+			return err
+		}
+	}
+	keys, err := tx.GetAPIKeysByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k.RevokedAt != 0 {
+			continue
+		}
+		k.RevokedAt = time.Now().UTC().Unix()
+		err := tx.UpdateAPIKey(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAccount deletes the user for good, dealing with their chirps as
+// the chirp deletion policy says.
+func (cfg *apiConfig) deleteAccount(tx database.Tx, userID int) error {
+	chirps, err := tx.GetChirpsByAuthor(userID)
+	if err != nil {
+		return err
+	}
+	for _, c := range chirps {
+		// A rechirp without its author reposts for nobody, so it goes
+		// either way.
+		if cfg.chirpDeletion == chirpDeletionAnonymize && c.RechirpOf == 0 {
+			c.AuthorID = 0
+			err = tx.UpdateChirp(c)
+		} else {
+			err = tx.DeleteChirp(c.ID)
+		}
+		// Rechirps of the user's own chirps went with them.
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.DeleteUser(userID)
+}
+
+// purgeDeletedAccounts deletes every account whose grace period is over.
+// Each one is deleted in a transaction of its own, which checks again that
+// the request wasn't taken back in the meantime.
+func (cfg *apiConfig) purgeDeletedAccounts() {
+	now := time.Now().UTC().Unix()
+	var users []database.User
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		users, err = tx.GetUsersToDelete(now)
+		return err
+	})
+	if err != nil {
+		log.Printf("Unable to look up accounts to delete: %s", err)
+		return
+	}
+	for _, u := range users {
+		deleted := false
+		err := cfg.db.Update(func(tx database.Tx) error {
+			user, err := tx.GetUserByID(u.ID)
+			if err != nil {
+				return err
+			}
+			if user.DeleteAfter == 0 || user.DeleteAfter > now {
+				return nil
+			}
+			deleted = true
+			return cfg.deleteAccount(tx, user.ID)
+		})
+		if err != nil {
+			log.Printf("Unable to delete account of user %d: %s", u.ID, err)
+			continue
+		}
+		if deleted {
+			log.Printf("Deleted account of user %d", u.ID)
+		}
+	}
+}
+
+// purgeDeletedAccountsEvery runs purgeDeletedAccounts now and then every
+// interval in the background.
+func (cfg *apiConfig) purgeDeletedAccountsEvery(interval time.Duration) {
+	go func() {
+		for {
+			cfg.purgeDeletedAccounts()
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// API keys are "chirpy_" followed by 32 random bytes in hex. The first
+// apiKeyPrefixLen characters are kept in the clear so users can tell their
+// keys apart; the rest is only stored hashed.
+const (
+	apiKeyScheme      = "chirpy_"
+	apiKeyPrefixLen   = len(apiKeyScheme) + 8
+	maxAPIKeyLabelLen = 100
+	// apiKeyLastUsedGranularity is how stale LastUsedAt may get before a
+	// request writes it again, so busy scripts don't write on every call.
+	apiKeyLastUsedGranularity = time.Minute
+)
+
+var errInvalidAPIKey = errors.New("Invalid or revoked API key")
+
+// APIKeyResponse is an API key as shown to its owner. Key is only set in
+// the answer to the request that created it.
+type APIKeyResponse struct {
+	ID         int        `json:"id"`
+	Label      string     `json:"label"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func unixTimeOrNil(t int64) *time.Time {
+	if t == 0 {
+		return nil
+	}
+	u := time.Unix(t, 0).UTC()
+	return &u
+}
+
+func newAPIKeyResponse(k database.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Label:      k.Label,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		CreatedAt:  time.Unix(k.CreatedAt, 0).UTC(),
+		ExpiresAt:  unixTimeOrNil(k.ExpiresAt),
+		LastUsedAt: unixTimeOrNil(k.LastUsedAt),
+		LastUsedIP: k.LastUsedIP,
+		RevokedAt:  unixTimeOrNil(k.RevokedAt),
+	}
+}
+
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyScheme + hex.EncodeToString(b), nil
+}
+
+// hashAPIKey is how API keys are stored and looked up. The keys are random
+// enough that a plain SHA-256 is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIKey returns the caller behind an API key. The key grants
+// the scopes it was made with that its user still has.
+func (cfg *apiConfig) authenticateAPIKey(r *http.Request, key string) (Principal, error) {
+	now := time.Now().UTC()
+	var p Principal
+	// recorded is true when the key's last use is recent enough already.
+	var recorded bool
+	err := cfg.db.View(func(tx database.Tx) error {
+		k, err := tx.GetAPIKey(hashAPIKey(key))
+		if errors.Is(err, database.ErrNotFound) {
+			return errInvalidAPIKey
+		}
+		if err != nil {
+			return err
+		}
+		if !k.Active(now) {
+			return errInvalidAPIKey
+		}
+		user, err := tx.GetUserByID(k.UserID)
+		if errors.Is(err, database.ErrNotFound) {
+			return errInvalidAPIKey
+		}
+		if err != nil {
+			return err
+		}
+		p = Principal{
+			UserID:   user.ID,
+			Role:     roleOf(user),
+			Scopes:   grantedScopes(user, k.Scopes),
+			APIKeyID: k.ID,
+		}
+		recorded = now.Unix()-k.LastUsedAt < int64(apiKeyLastUsedGranularity/time.Second) && k.LastUsedIP == clientIP(r)
+		return nil
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+	if recorded {
+		return p, nil
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		k, err := tx.GetAPIKeyByID(p.APIKeyID)
+		if err != nil {
+			return err
+		}
+		k.LastUsedAt = now.Unix()
+		k.LastUsedIP = clientIP(r)
+		return tx.UpdateAPIKey(k)
+	})
+	if err != nil {
+		// The key is good; failing to note its use shouldn't fail the request.
+		log.Printf("Unable to record use of API key %d: %s", p.APIKeyID, err)
+	}
+	return p, nil
+}
+
+// ownAPIKey loads the caller's API key named in the path. Someone else's key
+// is reported as missing so key IDs can't be probed.
+func ownAPIKey(tx database.Tx, userID int, keyID int) (database.APIKey, error) {
+	k, err := tx.GetAPIKeyByID(keyID)
+	if err != nil {
+		return database.APIKey{}, err
+	}
+	if k.UserID != userID {
+		return database.APIKey{}, database.ErrNotFound
+	}
+	return k, nil
+}
+
+// handlerCreateAPIKey makes a new API key for the caller. It may only carry
+// scopes the caller has and defaults to all of them. The key itself is in
+// the answer and can't be seen again.
+func (cfg *apiConfig) handlerCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	params := struct {
+		Label            string    `json:"label"`
+		Scopes           *[]string `json:"scopes"`
+		ExpiresInSeconds int       `json:"expires_in_seconds"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	if len(params.Label) > maxAPIKeyLabelLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Label is longer than %d characters", maxAPIKeyLabelLen))
+		return
+	}
+	if params.ExpiresInSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_seconds can't be negative")
+		return
+	}
+	scopes := principal.Scopes
+	if params.Scopes != nil {
+		scopes = []string{}
+		for _, s := range *params.Scopes {
+			if !validScope(s) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", s))
+				return
+			}
+			if !principal.HasScope(s) {
+				respondWithError(w, http.StatusForbidden, fmt.Sprintf("Missing scope %s", s))
+				return
+			}
+			if !slices.Contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create API key.")
+		return
+	}
+	now := time.Now().UTC()
+	k := database.APIKey{
+		UserID:    principal.UserID,
+		Label:     params.Label,
+		Prefix:    key[:apiKeyPrefixLen],
+		KeyHash:   hashAPIKey(key),
+		Scopes:    scopes,
+		CreatedAt: now.Unix(),
+	}
+	if params.ExpiresInSeconds > 0 {
+		k.ExpiresAt = now.Add(time.Duration(params.ExpiresInSeconds) * time.Second).Unix()
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		k, err = tx.CreateAPIKey(k)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create API key.")
+		return
+	}
+	resp := newAPIKeyResponse(k)
+	resp.Key = key
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// handlerGetAPIKeys lists the caller's API keys, revoked ones included.
+func (cfg *apiConfig) handlerGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var keys []database.APIKey
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		keys, err = tx.GetAPIKeysByUser(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve API keys.")
+		return
+	}
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, newAPIKeyResponse(k))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerUpdateAPIKey renames one of the caller's API keys.
+func (cfg *apiConfig) handlerUpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	keyID, err := strconv.Atoi(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid keyID %v", r.PathValue("keyID")))
+		return
+	}
+	params := struct {
+		Label string `json:"label"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	if len(params.Label) > maxAPIKeyLabelLen {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Label is longer than %d characters", maxAPIKeyLabelLen))
+		return
+	}
+	var k database.APIKey
+	err = cfg.db.Update(func(tx database.Tx) error {
+		k, err = ownAPIKey(tx, principal.UserID, keyID)
+		if err != nil {
+			return err
+		}
+		k.Label = params.Label
+		return tx.UpdateAPIKey(k)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("API key with ID %v not found", keyID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update API key.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newAPIKeyResponse(k))
+}
+
+// handlerDeleteAPIKey revokes one of the caller's API keys. A key may
+// revoke itself, so a leaked key can be shut off with nothing but the key.
+func (cfg *apiConfig) handlerDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	keyID, err := strconv.Atoi(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid keyID %v", r.PathValue("keyID")))
+		return
+	}
+	if !principal.FirstParty() && principal.APIKeyID != keyID {
+		respondWithError(w, http.StatusForbidden, "API keys can only revoke themselves.")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		k, err := ownAPIKey(tx, principal.UserID, keyID)
+		if err != nil {
+			return err
+		}
+		if k.RevokedAt != 0 {
+			return nil
+		}
+		k.RevokedAt = time.Now().UTC().Unix()
+		return tx.UpdateAPIKey(k)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("API key with ID %v not found", keyID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to revoke API key.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
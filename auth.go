@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+)
+
+// keyringFromEnv signs with the keys in JWT_KEYS_DIR when it is set and
+// with the HS256 secret JWT_SECRET otherwise. Alongside keys, HS256 tokens
+// are only accepted if JWT_LEGACY_HS256_SECRET is set, and only until
+// JWT_LEGACY_HS256_UNTIL, an RFC 3339 time, which it requires. That lets
+// tokens issued before the switch run out without leaving the shared
+// secret able to mint new ones for good.
+func keyringFromEnv() (*auth.Keyring, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET not found in .env file")
+		}
+		return auth.NewHMACKeyring(secret), nil
+	}
+	if os.Getenv("JWT_SECRET") != "" {
+		log.Printf("JWT_SECRET is ignored because JWT_KEYS_DIR is set; see JWT_LEGACY_HS256_SECRET")
+	}
+	legacySecret := os.Getenv("JWT_LEGACY_HS256_SECRET")
+	if legacySecret == "" {
+		return auth.LoadKeyring(dir, "", time.Time{})
+	}
+	until, err := time.Parse(time.RFC3339, os.Getenv("JWT_LEGACY_HS256_UNTIL"))
+	if err != nil {
+		return nil, errors.New("JWT_LEGACY_HS256_UNTIL must be an RFC 3339 time when JWT_LEGACY_HS256_SECRET is set")
+	}
+	if time.Now().Before(until) {
+		log.Printf("Accepting HS256 tokens signed with JWT_LEGACY_HS256_SECRET until %s", until.UTC().Format(time.RFC3339))
+	}
+	return auth.LoadKeyring(dir, legacySecret, until)
+}
+
+// argon2ParamsFromEnv starts from auth.DefaultArgon2idParams and applies
+// ARGON2_MEMORY (KiB), ARGON2_TIME and ARGON2_THREADS where set. Existing
+// hashes are upgraded to new parameters as their users log in.
+func argon2ParamsFromEnv() (auth.Argon2idParams, error) {
+	params := auth.DefaultArgon2idParams
+	for _, v := range []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"ARGON2_MEMORY", 32, func(n uint64) { params.Memory = uint32(n) }},
+		{"ARGON2_TIME", 32, func(n uint64) { params.Time = uint32(n) }},
+		{"ARGON2_THREADS", 8, func(n uint64) { params.Threads = uint8(n) }},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, v.bits)
+		if err != nil || n == 0 {
+			return auth.Argon2idParams{}, fmt.Errorf("%s must be a positive number", v.name)
+		}
+		v.set(n)
+	}
+	return params, nil
+}
+
+// passwordPolicyFromEnv starts from auth.DefaultPasswordPolicy and applies
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and PASSWORD_REQUIRE, a comma
+// separated list of lowercase, uppercase, digit and symbol.
+func passwordPolicyFromEnv() (auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PASSWORD_MIN_LENGTH", &policy.MinLength},
+		{"PASSWORD_MAX_LENGTH", &policy.MaxLength},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return auth.PasswordPolicy{}, fmt.Errorf("%s must be a number", v.name)
+		}
+		*v.dst = n
+	}
+	if policy.MaxLength > 0 && policy.MinLength > policy.MaxLength {
+		return auth.PasswordPolicy{}, errors.New("PASSWORD_MIN_LENGTH is greater than PASSWORD_MAX_LENGTH")
+	}
+	for _, class := range strings.Split(os.Getenv("PASSWORD_REQUIRE"), ",") {
+		switch strings.TrimSpace(class) {
+		case "":
+		case auth.RuleLower:
+			policy.RequireLower = true
+		case auth.RuleUpper:
+			policy.RequireUpper = true
+		case auth.RuleDigit:
+			policy.RequireDigit = true
+		case auth.RuleSymbol:
+			policy.RequireSymbol = true
+		default:
+			return auth.PasswordPolicy{}, fmt.Errorf("Unknown character class in PASSWORD_REQUIRE: %s", class)
+		}
+	}
+	return policy, nil
+}
+
+// reloadKeysOnHangup re-reads the key directory whenever the process gets
+// SIGHUP, so a rotated key takes effect without a restart.
+func reloadKeysOnHangup(keys *auth.Keyring) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := keys.Reload()
+			if err != nil {
+				log.Printf("Unable to reload JWT keys: %s", err)
+				continue
+			}
+			log.Printf("Reloaded JWT keys")
+		}
+	}()
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.keys.JWKS())
+}
@@ -0,0 +1,227 @@
+// Package auth handles Chirpy's credentials: it signs and verifies JWTs and
+// hashes passwords.
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ActiveFile names the file in a key directory that holds the kid of the
+// key new tokens are signed with.
+const ActiveFile = "active"
+
+// Key is one signing key. Its kid is the file name without ".pem".
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// Keyring holds every key tokens may be verified with and the one new
+// tokens are signed with.
+//
+// Keys are PEM files in a directory, one per kid. Every key in the
+// directory is published in the JWKS and accepted for verification, but
+// only the one named in the "active" file signs. That allows staged
+// rotation: add the new key, wait for verifiers to pick up the JWKS, switch
+// "active" to it, and remove the old key once its tokens have expired.
+// Reload picks up changes without a restart.
+//
+// A keyring may also carry a shared HS256 secret. With no key directory it
+// signs with that secret, as Chirpy always did. Alongside a directory it is
+// only accepted for verification, and only until secretUntil, so tokens
+// issued before the switch keep working until they expire and no longer.
+type Keyring struct {
+	mux         *sync.RWMutex
+	dir         string
+	keys        map[string]*Key
+	active      string
+	secret      []byte
+	secretUntil time.Time
+}
+
+// NewHMACKeyring returns a keyring that signs and verifies with a shared
+// HS256 secret only.
+func NewHMACKeyring(secret string) *Keyring {
+	return &Keyring{
+		mux:    &sync.RWMutex{},
+		keys:   make(map[string]*Key),
+		secret: []byte(secret),
+	}
+}
+
+// LoadKeyring loads the keys in dir. legacySecret may be empty; otherwise
+// HS256 tokens signed with it are still accepted until legacyUntil.
+func LoadKeyring(dir string, legacySecret string, legacyUntil time.Time) (*Keyring, error) {
+	if legacySecret != "" && legacyUntil.IsZero() {
+		return nil, errors.New("A legacy HS256 secret needs a time to stop accepting it")
+	}
+	k := &Keyring{
+		mux:         &sync.RWMutex{},
+		dir:         dir,
+		secret:      []byte(legacySecret),
+		secretUntil: legacyUntil,
+	}
+	err := k.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the key directory. On error the keyring keeps its
+// current keys.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*Key)
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+	}
+	dat, err := os.ReadFile(filepath.Join(k.dir, ActiveFile))
+	if err != nil {
+		return fmt.Errorf("Unable to read active key id: %s", err)
+	}
+	active := strings.TrimSpace(string(dat))
+	if _, ok := keys[active]; !ok {
+		return fmt.Errorf("Active key %q not found in %s", active, k.dir)
+	}
+
+	defer k.mux.Unlock()
+	k.mux.Lock()
+	k.keys = keys
+	k.active = active
+	return nil
+}
+
+func loadKey(path string) (*Key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", path)
+	}
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse key %s: %s", path, err)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key %s is shorter than 2048 bits", path)
+		}
+		return &Key{id, jwt.SigningMethodRS256, key}, nil
+	case ed25519.PrivateKey:
+		return &Key{id, jwt.SigningMethodEdDSA, key}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type in %s", path)
+}
+
+// Sign signs claims with the active key and names it in the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	defer k.mux.RUnlock()
+	k.mux.RLock()
+	if k.active == "" {
+		if len(k.secret) == 0 {
+			return "", errors.New("No signing key configured")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+	key := k.keys[k.active]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Parse verifies tokenString and decodes it into claims. The key is chosen
+// by kid and must match the algorithm in the header, so a token can't pick
+// a weaker algorithm than its key was issued for.
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, k.keyfunc, jwt.WithValidMethods(k.methods()))
+}
+
+// acceptsSecret reports whether HS256 tokens signed with the shared secret
+// are accepted.
+func (k *Keyring) acceptsSecret() bool {
+	if len(k.secret) == 0 {
+		return false
+	}
+	return k.secretUntil.IsZero() || time.Now().Before(k.secretUntil)
+}
+
+func (k *Keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	defer k.mux.RUnlock()
+	k.mux.RLock()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if token.Method == jwt.SigningMethodHS256 && k.acceptsSecret() {
+			return k.secret, nil
+		}
+		return nil, errors.New("Token has no key id")
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public(), nil
+}
+
+func (k *Keyring) methods() []string {
+	defer k.mux.RUnlock()
+	k.mux.RLock()
+	methods := []string{}
+	seen := make(map[string]bool)
+	for _, key := range k.keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	if k.acceptsSecret() {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeKey writes a new Ed25519 key named id to dir and makes it active.
+func writeKey(t *testing.T, dir string, id string) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, id+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, ActiveFile), []byte(id+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signHS256(t *testing.T, secret string, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestKeyringParse(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old")
+	old, err := LoadKeyring(dir, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedOld, err := old.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "new")
+
+	tests := []struct {
+		name         string
+		legacySecret string
+		legacyUntil  time.Time
+		token        string
+		wantErr      bool
+	}{
+		{"retired key still verifies", "", time.Time{}, signedOld, false},
+		{"HS256 without a legacy secret", "", time.Time{}, signHS256(t, "secret", ""), true},
+		{"HS256 before the legacy cutoff", "secret", time.Now().Add(time.Hour), signHS256(t, "secret", ""), false},
+		{"HS256 after the legacy cutoff", "secret", time.Now().Add(-time.Hour), signHS256(t, "secret", ""), true},
+		{"HS256 with the wrong secret", "secret", time.Now().Add(time.Hour), signHS256(t, "other", ""), true},
+		{"HS256 naming an Ed25519 key", "secret", time.Now().Add(time.Hour), signHS256(t, "secret", "new"), true},
+		{"unknown key id", "", time.Time{}, signHS256(t, "secret", "missing"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := LoadKeyring(dir, tt.legacySecret, tt.legacyUntil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = k.Parse(tt.token, &jwt.RegisteredClaims{})
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("Parse returned %v, want error = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadKeyringNeedsLegacyCutoff(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "key")
+	_, err := LoadKeyring(dir, "secret", time.Time{})
+	if err == nil {
+		t.Error("LoadKeyring accepted a legacy secret without a cutoff")
+	}
+}
+
+func TestKeyringSignsWithActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "first")
+	k, err := LoadKeyring(dir, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "second")
+	err = k.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := k.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := k.Parse(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != "second" {
+		t.Errorf("token is signed with %v, want second", kid)
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep argon2id cheap enough for tests.
+var testParams = Argon2idParams{
+	Memory:     64,
+	Time:       1,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestDecodeArgon2id(t *testing.T) {
+	// 16 zero bytes of salt and 32 of key.
+	salt := strings.Repeat("A", 22)
+	key := strings.Repeat("A", 43)
+	tests := []struct {
+		name    string
+		encoded string
+		want    Argon2idParams
+		wantErr bool
+	}{
+		{"valid", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key, testParams, false},
+		{"short key", "$argon2id$v=19$m=65536,t=3,p=4$" + salt + "$" + strings.Repeat("A", 22),
+			Argon2idParams{Memory: 65536, Time: 3, Threads: 4, SaltLength: 16, KeyLength: 16}, false},
+		{"argon2i", "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key, Argon2idParams{}, true},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key, Argon2idParams{}, true},
+		{"no version", "$argon2id$m=64,t=1,p=1$" + salt + "$" + key, Argon2idParams{}, true},
+		{"bad parameters", "$argon2id$v=19$m=64;t=1;p=1$" + salt + "$" + key, Argon2idParams{}, true},
+		{"padded salt", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "==$" + key, Argon2idParams{}, true},
+		{"bad hash", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$not base64!", Argon2idParams{}, true},
+		{"extra field", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$", Argon2idParams{}, true},
+		{"bcrypt", "$2a$10$" + strings.Repeat("a", 53), Argon2idParams{}, true},
+		{"empty", "", Argon2idParams{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := decodeArgon2id(tt.encoded)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decoded %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2idHasherVerify(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+	hash, err := h.Hash("chirp-chirp-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.Hash("chirp-chirp-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == other {
+		t.Error("two hashes of the same password are equal; the salt is not random")
+	}
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     error
+	}{
+		{"argon2id", hash, "chirp-chirp-42", nil},
+		{"argon2id wrong password", hash, "chirp-chirp-43", ErrPasswordMismatch},
+		{"bcrypt", bcryptHash(t, "chirp-chirp-42"), "chirp-chirp-42", nil},
+		{"bcrypt wrong password", bcryptHash(t, "chirp-chirp-42"), "chirp-chirp-43", ErrPasswordMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.Verify(tt.encoded, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	err = h.Verify("plaintext", "plaintext")
+	if err == nil || errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Verify of an unknown format returned %v, want a format error", err)
+	}
+}
+
+func TestArgon2idHasherNeedsRehash(t *testing.T) {
+	h := NewArgon2idHasher(testParams)
+	hashWith := func(change func(p *Argon2idParams)) string {
+		p := testParams
+		change(&p)
+		hash, err := NewArgon2idHasher(p).Hash("chirp-chirp-42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	tests := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{"current", hashWith(func(p *Argon2idParams) {}), false},
+		{"less memory", hashWith(func(p *Argon2idParams) { p.Memory = 32 }), true},
+		{"more passes", hashWith(func(p *Argon2idParams) { p.Time = 2 }), true},
+		{"more threads", hashWith(func(p *Argon2idParams) { p.Threads = 2 }), true},
+		{"shorter salt", hashWith(func(p *Argon2idParams) { p.SaltLength = 8 }), true},
+		{"shorter key", hashWith(func(p *Argon2idParams) { p.KeyLength = 16 }), true},
+		{"bcrypt", bcryptHash(t, "chirp-chirp-42"), true},
+		{"unknown", "plaintext", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
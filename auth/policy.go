@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the bundled list of passwords that are always guessed
+// first. It is checked offline; nothing is sent anywhere.
+var commonPasswords = func() map[string]bool {
+	m := map[string]bool{}
+	s := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m[line] = true
+	}
+	return m
+}()
+
+// Rules a password can break.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleLower     = "lowercase"
+	RuleUpper     = "uppercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleCommon    = "common"
+	RuleEmail     = "email"
+)
+
+// Violation is one rule a password breaks.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy decides which passwords users may choose. Lengths count
+// characters, not bytes.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectCommon rejects passwords on the bundled common-password list.
+	RejectCommon bool
+	// RejectEmail rejects passwords that are, contain, or are nearly the
+	// user's email address or its local part.
+	RejectEmail bool
+}
+
+// DefaultPasswordPolicy favours length over composition, as NIST SP
+// 800-63B does.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    8,
+	MaxLength:    128,
+	RejectCommon: true,
+	RejectEmail:  true,
+}
+
+// Check returns every rule password breaks for a user with the given
+// email, or nil if it is acceptable.
+func (p PasswordPolicy) Check(password string, email string) []Violation {
+	var violations []Violation
+	add := func(rule string, format string, args ...any) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		add(RuleMinLength, "Password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(RuleMaxLength, "Password must be at most %d characters", p.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireLower && !lower {
+		add(RuleLower, "Password must contain a lowercase letter")
+	}
+	if p.RequireUpper && !upper {
+		add(RuleUpper, "Password must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(RuleDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(RuleSymbol, "Password must contain a symbol")
+	}
+
+	folded := strings.ToLower(password)
+	if p.RejectCommon && commonPasswords[folded] {
+		add(RuleCommon, "Password is too common")
+	}
+	if p.RejectEmail && similarToEmail(folded, strings.ToLower(email)) {
+		add(RuleEmail, "Password must not resemble your email address")
+	}
+	return violations
+}
+
+func similarToEmail(password string, email string) bool {
+	if email == "" {
+		return false
+	}
+	local, _, _ := strings.Cut(email, "@")
+	for _, s := range []string{email, local} {
+		// Very short local parts would reject too much when contained.
+		if len(s) >= 3 && strings.Contains(password, s) {
+			return true
+		}
+		if levenshtein(password, s) <= 2 {
+			return true
+		}
+	}
+	return false
+}
+
+func levenshtein(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	prev := make([]int, len(y)+1)
+	cur := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		cur[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(y)]
+}
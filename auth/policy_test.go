@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{
+		MinLength:     8,
+		MaxLength:     16,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		email    string
+		want     []string
+	}{
+		{"default accepts a long passphrase", DefaultPasswordPolicy, "correct horse battery", "alice@example.com", nil},
+		{"too short", DefaultPasswordPolicy, "chirp1", "alice@example.com", []string{RuleMinLength}},
+		// Eight characters but sixteen bytes.
+		{"length counts characters", DefaultPasswordPolicy, "ĉĥïŕṗŷğø", "alice@example.com", nil},
+		{"seven multibyte characters", DefaultPasswordPolicy, "ĉĥïŕṗŷğ", "alice@example.com", []string{RuleMinLength}},
+		{"too long", strict, "Chirp-chirp-42-chirp", "", []string{RuleMaxLength}},
+		{"strict accepts all classes", strict, "Chirp-chirp-42", "", nil},
+		{"no classes", strict, "        ", "", []string{RuleLower, RuleUpper, RuleDigit, RuleSymbol}},
+		{"no upper", strict, "chirp-chirp-42", "", []string{RuleUpper}},
+		{"no lower", strict, "CHIRP-CHIRP-42", "", []string{RuleLower}},
+		{"no digit", strict, "Chirp-chirp-xx", "", []string{RuleDigit}},
+		{"no symbol", strict, "Chirpchirp42", "", []string{RuleSymbol}},
+		{"non-ASCII letters count", strict, "Çhirp-çhirp-42", "", nil},
+		{"common", DefaultPasswordPolicy, "password1", "alice@example.com", []string{RuleCommon}},
+		{"common in other case", DefaultPasswordPolicy, "IloveYou", "alice@example.com", []string{RuleCommon}},
+		{"common and short", DefaultPasswordPolicy, "1234", "alice@example.com", []string{RuleMinLength, RuleCommon}},
+		{"common allowed when off", PasswordPolicy{MinLength: 8}, "password1", "", nil},
+		{"is the email", DefaultPasswordPolicy, "alice@example.com", "alice@example.com", []string{RuleEmail}},
+		{"contains the local part", DefaultPasswordPolicy, "xxALICExx-2024", "alice@example.com", []string{RuleEmail}},
+		{"near the email", DefaultPasswordPolicy, "alice@exampel.com", "alice@example.com", []string{RuleEmail}},
+		{"near the local part", DefaultPasswordPolicy, "alicia12", "alicia@example.com", []string{RuleEmail}},
+		{"two edits from the local part", DefaultPasswordPolicy, "bartholomew", "bartolomeo@example.com", []string{RuleEmail}},
+		{"three edits from the local part", DefaultPasswordPolicy, "bertholomew", "bartolomeo@example.com", nil},
+		{"short local part only matches whole", DefaultPasswordPolicy, "jo-and-the-chirps", "jo@example.com", nil},
+		{"email allowed when off", PasswordPolicy{MinLength: 8}, "alice@example.com", "alice@example.com", nil},
+		{"no email to compare", DefaultPasswordPolicy, "chirp-chirp-42", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, v := range tt.policy.Check(tt.password, tt.email) {
+				got = append(got, v.Rule)
+				if v.Message == "" {
+					t.Errorf("rule %s has no message", v.Rule)
+				}
+			}
+			want := tt.want
+			if want == nil {
+				want = []string{}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Check(%q) broke %v, want %v", tt.password, got, want)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"chirp", "chirp", 0},
+		{"chirp", "chrip", 2},
+		{"chirp", "chirps", 1},
+		{"kitten", "sitting", 3},
+		// Distances count characters, not bytes.
+		{"café", "cafe", 1},
+		{"ĉĥïŕṗ", "chirp", 5},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
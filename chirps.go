@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+const (
+	maxChirpLength = 140
+	// defaultChirpEditWindow is how long after posting authors may edit a
+	// chirp, unless CHIRP_EDIT_WINDOW says otherwise.
+	defaultChirpEditWindow = 15 * time.Minute
+)
+
+var profaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+var (
+	errChirpTooLong   = errors.New("Chirp is too long")
+	errNoSuchOriginal = errors.New("The chirp you are quoting does not exist.")
+	errEmptyQuote     = errors.New("A quote needs a body of its own.")
+)
+
+// ChirpResponse is a chirp as the API shows it.
+type ChirpResponse struct {
+	ID        int        `json:"id"`
+	Body      string     `json:"body"`
+	AuthorID  int        `json:"author_id"`
+	CreatedAt *time.Time `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	Revisions int        `json:"revisions"`
+	// InReplyTo is null for chirps that start a conversation, whose
+	// ConversationID is their own ID.
+	InReplyTo      *int `json:"in_reply_to"`
+	ConversationID int  `json:"conversation_id"`
+	// A rechirp has an empty body and shows the chirp it reposts in
+	// Rechirped. A quote shows the chirp it quotes in Quoted, until that
+	// chirp is deleted. Chirps shown there don't show what they repost or
+	// quote in turn.
+	RechirpOf *int           `json:"rechirp_of"`
+	Rechirped *ChirpResponse `json:"rechirped,omitempty"`
+	QuoteOf   *int           `json:"quote_of"`
+	Quoted    *ChirpResponse `json:"quoted,omitempty"`
+	Likes     int            `json:"likes"`
+	// LikedByMe is only set for signed-in callers.
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	Rechirps  int   `json:"rechirps"`
+}
+
+func newChirpResponse(c database.Chirp) ChirpResponse {
+	return ChirpResponse{
+		ID:             c.ID,
+		Body:           c.Body,
+		AuthorID:       c.AuthorID,
+		CreatedAt:      unixTimeOrNil(c.CreatedAt),
+		EditedAt:       unixTimeOrNil(c.EditedAt),
+		Revisions:      revisionOf(c),
+		InReplyTo:      intOrNil(c.InReplyTo),
+		ConversationID: conversationOf(c),
+		RechirpOf:      intOrNil(c.RechirpOf),
+		QuoteOf:        intOrNil(c.QuoteOf),
+	}
+}
+
+func intOrNil(n int) *int {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+// conversationOf returns the ID of the chirp that started the conversation
+// c is part of.
+func conversationOf(c database.Chirp) int {
+	if c.ConversationID == 0 {
+		return c.ID
+	}
+	return c.ConversationID
+}
+
+// originalOf returns the chirp c reposts if it is a rechirp, and c itself
+// otherwise. Replies, quotes, likes and rechirps all go to the original.
+func originalOf(tx database.Tx, c database.Chirp) (database.Chirp, error) {
+	if c.RechirpOf == 0 {
+		return c, nil
+	}
+	return tx.GetChirp(c.RechirpOf)
+}
+
+// chirpResponses returns chirps as the API shows them to viewerID, who is
+// 0 for anonymous callers.
+func chirpResponses(tx database.Tx, viewerID int, chirps ...database.Chirp) ([]ChirpResponse, error) {
+	resp := make([]ChirpResponse, 0, len(chirps))
+	ptrs := make([]*ChirpResponse, 0, len(chirps))
+	for _, c := range chirps {
+		resp = append(resp, newChirpResponse(c))
+	}
+	for i := range resp {
+		ptrs = append(ptrs, &resp[i])
+	}
+	err := completeChirpResponses(tx, viewerID, ptrs...)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// completeChirpResponses fills in what the chirps alone don't tell: the
+// chirps they repost or quote, how many likes and rechirps each has and,
+// unless viewerID is 0, whether viewerID likes it. Everything is read in
+// the caller's transaction, so the counts agree with each other and with
+// the chirps.
+func completeChirpResponses(tx database.Tx, viewerID int, chirps ...*ChirpResponse) error {
+	all := slices.Clone(chirps)
+	for _, c := range chirps {
+		originalID, shown := c.RechirpOf, &c.Rechirped
+		if originalID == nil {
+			originalID, shown = c.QuoteOf, &c.Quoted
+		}
+		if originalID == nil {
+			continue
+		}
+		original, err := tx.GetChirp(*originalID)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		o := newChirpResponse(original)
+		*shown = &o
+		all = append(all, &o)
+	}
+
+	ids := make([]int, 0, len(all))
+	for _, c := range all {
+		ids = append(ids, c.ID)
+	}
+	counts, err := tx.CountLikes(ids)
+	if err != nil {
+		return err
+	}
+	rechirps, err := tx.CountRechirps(ids)
+	if err != nil {
+		return err
+	}
+	var liked map[int]bool
+	if viewerID != 0 {
+		liked, err = tx.GetLikedChirps(viewerID, ids)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range all {
+		c.Likes = counts[c.ID]
+		c.Rechirps = rechirps[c.ID]
+		if viewerID != 0 {
+			likedByMe := liked[c.ID]
+			c.LikedByMe = &likedByMe
+		}
+	}
+	return nil
+}
+
+// viewerOf returns the ID of the signed-in caller of r, or 0.
+func viewerOf(r *http.Request) int {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		return 0
+	}
+	return principal.UserID
+}
+
+// revisionOf returns the number of the current version of c. Chirps from
+// before edits were counted have only ever had one.
+func revisionOf(c database.Chirp) int {
+	return max(c.Revisions, 1)
+}
+
+// bodyWrittenAt returns when the current body of c was written.
+func bodyWrittenAt(c database.Chirp) int64 {
+	if c.EditedAt != 0 {
+		return c.EditedAt
+	}
+	return c.CreatedAt
+}
+
+// cleanChirpBody checks that body fits in a chirp and masks profanity in
+// it. Every body stored goes through it, whether posted or edited.
+func cleanChirpBody(body string) (string, error) {
+	if len(body) > maxChirpLength {
+		return "", errChirpTooLong
+	}
+	return getCleanedBody(profaneWords, body), nil
+}
@@ -0,0 +1,88 @@
+// Command chirpy-keygen adds a signing key to a Chirpy key directory.
+//
+//	chirpy-keygen [-dir keys] [-alg EdDSA|RS256] [-activate] <kid>
+//
+// Without -activate the key is only published, which is the first step of
+// a staged rotation. Run again with -activate (or edit the "active" file)
+// once verifiers have picked it up, then send the server SIGHUP.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bigbabyjack/chirpy/auth"
+)
+
+func main() {
+	dir := flag.String("dir", "keys", "Key directory")
+	alg := flag.String("alg", "EdDSA", "Key algorithm: EdDSA or RS256")
+	activate := flag.Bool("activate", false, "Make this the signing key")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: chirpy-keygen [flags] <kid>\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	kid := flag.Arg(0)
+	path := filepath.Join(*dir, kid+".pem")
+
+	if _, err := os.Stat(path); err == nil {
+		if !*activate {
+			log.Fatalf("Key %s already exists", path)
+		}
+	} else {
+		err := writeKey(path, *alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s", path)
+	}
+
+	if *activate {
+		err := os.WriteFile(filepath.Join(*dir, auth.ActiveFile), []byte(kid+"\n"), 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Activated %s", kid)
+	}
+}
+
+func writeKey(path string, alg string) error {
+	var der []byte
+	var err error
+	switch alg {
+	case "EdDSA":
+		var key ed25519.PrivateKey
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	case "RS256":
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			return err
+		}
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	default:
+		return fmt.Errorf("Unknown algorithm %s", alg)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
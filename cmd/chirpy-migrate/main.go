@@ -0,0 +1,102 @@
+// Command chirpy-migrate manages the schema of the SQLite store and copies
+// an existing database.json into it.
+//
+//	chirpy-migrate [-db chirpy.db] status
+//	chirpy-migrate [-db chirpy.db] up
+//	chirpy-migrate [-db chirpy.db] down [steps]
+//	chirpy-migrate [-db chirpy.db] to <version>
+//	chirpy-migrate [-db chirpy.db] [-json database.json] import-json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func main() {
+	dbPath := flag.String("db", "chirpy.db", "Path to the SQLite database")
+	jsonPath := flag.String("json", database.DB_PATH, "Path to the JSON database to import")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: chirpy-migrate [flags] status|up|down [steps]|to <version>|import-json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "import-json":
+		err := importJSON(*jsonPath, *dbPath)
+		if err != nil {
+			log.Fatalf("Import failed: %s", err)
+		}
+		log.Printf("Imported %s into %s", *jsonPath, *dbPath)
+		return
+	}
+
+	db, err := database.OpenSQLite(*dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "status":
+	case "up":
+		err = migrator.Up()
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil {
+				log.Fatalf("Invalid number of steps: %s", flag.Arg(1))
+			}
+		}
+		err = migrator.Down(steps)
+	case "to":
+		if flag.NArg() < 2 {
+			log.Fatalf("Missing target version")
+		}
+		target, convErr := strconv.Atoi(flag.Arg(1))
+		if convErr != nil {
+			log.Fatalf("Invalid version: %s", flag.Arg(1))
+		}
+		err = migrator.MigrateTo(target)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Schema version %d (latest %d)\n", version, migrator.Latest())
+}
+
+func importJSON(jsonPath, dbPath string) error {
+	dbStructure, err := database.ReadSnapshot(jsonPath)
+	if err != nil {
+		return err
+	}
+	dst, err := database.NewSQLiteStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	return dst.Import(dbStructure)
+}
@@ -0,0 +1,243 @@
+// Command chirpy-stub-idp is a minimal OpenID Connect provider for trying
+// out and testing Chirpy's external sign-in locally. It signs in whoever
+// you say you are, so never expose it.
+//
+//	chirpy-stub-idp [-addr :9090] [-client-id chirpy] [-client-secret secret]
+//
+// Point Chirpy at it with
+//
+//	OIDC_PROVIDERS=stub
+//	OIDC_STUB_ISSUER=http://localhost:9090
+//	OIDC_STUB_CLIENT_ID=chirpy
+//	OIDC_STUB_CLIENT_SECRET=secret
+//
+// Its sign-in page asks for the subject, email and whether the email is
+// verified. Scripts can skip the page by posting those fields, along with
+// the authorization request parameters, to /authorize.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "stub"
+
+// grant is an issued authorization code waiting to be exchanged.
+type grant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type stub struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mux    *sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	addr := flag.String("addr", ":9090", "Address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9090", "Issuer URL, as Chirpy reaches it")
+	clientID := flag.String("client-id", "chirpy", "Client ID Chirpy uses")
+	clientSecret := flag.String("client-secret", "secret", "Client secret Chirpy uses")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &stub{
+		issuer:       *issuer,
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		mux:          &sync.Mutex{},
+		grants:       map[string]grant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("GET /jwks", s.handleJWKS)
+	mux.HandleFunc("GET /authorize", s.handleAuthorizePage)
+	mux.HandleFunc("POST /authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /token", s.handleToken)
+	log.Printf("Stub identity provider %s listening on %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *stub) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *stub) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, auth.JWKS{Keys: []auth.JWK{{
+		KeyType: "RSA",
+		KeyID:   keyID,
+		Use:     "sig",
+		Alg:     "RS256",
+		N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<body>
+    <h1>Stub identity provider</h1>
+    <form method="post" action="/authorize">
+        {{range $name, $value := .}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+        {{end}}
+        <p><label>Subject <input name="sub" value="stub-user" required></label></p>
+        <p><label>Email <input type="email" name="email" required></label></p>
+        <p><label><input type="checkbox" name="email_verified" value="true" checked> Email is verified</label></p>
+        <button type="submit">Sign in</button>
+    </form>
+</body>
+</html>
+`))
+
+func (s *stub) handleAuthorizePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pageTemplate.Execute(w, r.URL.Query())
+}
+
+func (s *stub) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := r.PostForm
+	if f.Get("client_id") != s.clientID || f.Get("response_type") != "code" || f.Get("redirect_uri") == "" {
+		http.Error(w, "Invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if f.Get("code_challenge_method") != "S256" || f.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := hex.EncodeToString(b)
+	s.mux.Lock()
+	s.grants[code] = grant{
+		clientID:      f.Get("client_id"),
+		redirectURI:   f.Get("redirect_uri"),
+		nonce:         f.Get("nonce"),
+		codeChallenge: f.Get("code_challenge"),
+		subject:       f.Get("sub"),
+		email:         f.Get("email"),
+		emailVerified: f.Get("email_verified") == "true",
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	s.mux.Unlock()
+
+	u, err := url.Parse(f.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set("code", code)
+	q.Set("state", f.Get("state"))
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func (s *stub) handleToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(s.clientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mux.Lock()
+	code := r.PostForm.Get("code")
+	g, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mux.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || time.Now().After(g.expiresAt) || g.clientID != clientID ||
+		g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            g.subject,
+		"aud":            g.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	})
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "stub",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
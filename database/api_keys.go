@@ -0,0 +1,72 @@
+package database
+
+import "time"
+
+// APIKey is a long-lived credential a user made for a script or bot. Only a
+// hash of the key is stored; Prefix is the start of the key, kept so users
+// can tell their keys apart. A key only grants the scopes it was created
+// with, and only while its user still has them.
+type APIKey struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"user_id"`
+	Label      string   `json:"label"`
+	Prefix     string   `json:"prefix"`
+	KeyHash    string   `json:"key_hash"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	LastUsedIP string   `json:"last_used_ip"`
+	RevokedAt  int64    `json:"revoked_at"`
+}
+
+// Active reports whether the key may be used at time now. A zero ExpiresAt
+// never expires.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == 0 && (k.ExpiresAt == 0 || k.ExpiresAt > now.Unix())
+}
+
+func (tx *dbTx) CreateAPIKey(k APIKey) (APIKey, error) {
+	id, err := tx.nextID(apiKeysTable)
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.ID = id
+	err = tx.put(apiKeysTable, id, k)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+func (tx *dbTx) GetAPIKey(keyHash string) (APIKey, error) {
+	id, ok := tx.db.index.apiKeyByHash[keyHash]
+	if !ok {
+		return APIKey{}, notFound("API key not found")
+	}
+	return tx.db.data.Data.APIKeys[id], nil
+}
+
+func (tx *dbTx) GetAPIKeyByID(id int) (APIKey, error) {
+	k, ok := tx.db.data.Data.APIKeys[id]
+	if !ok {
+		return APIKey{}, notFound("API key %d not found", id)
+	}
+	return k, nil
+}
+
+func (tx *dbTx) GetAPIKeysByUser(userID int) ([]APIKey, error) {
+	ids := tx.db.index.apiKeysByUser[userID]
+	keys := make([]APIKey, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, tx.db.data.Data.APIKeys[id])
+	}
+	return keys, nil
+}
+
+func (tx *dbTx) UpdateAPIKey(k APIKey) error {
+	if _, ok := tx.db.data.Data.APIKeys[k.ID]; !ok {
+		return notFound("API key %d not found", k.ID)
+	}
+	return tx.put(apiKeysTable, k.ID, k)
+}
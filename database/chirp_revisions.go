@@ -0,0 +1,34 @@
+package database
+
+// ChirpRevision is an earlier version of a chirp's body, kept when the chirp
+// is edited. Revision numbers a chirp's versions from 1, the one it was
+// posted with. CreatedAt is when this version was written.
+type ChirpRevision struct {
+	ID        int    `json:"id"`
+	ChirpID   int    `json:"chirp_id"`
+	Revision  int    `json:"revision"`
+	Body      string `json:"body"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (tx *dbTx) CreateChirpRevision(r ChirpRevision) (ChirpRevision, error) {
+	id, err := tx.nextID(chirpRevisionsTable)
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	r.ID = id
+	err = tx.put(chirpRevisionsTable, id, r)
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	return r, nil
+}
+
+func (tx *dbTx) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	ids := tx.db.index.chirpRevisionsByChirp[chirpID]
+	revisions := make([]ChirpRevision, 0, len(ids))
+	for _, id := range ids {
+		revisions = append(revisions, tx.db.data.Data.ChirpRevisions[id])
+	}
+	return revisions, nil
+}
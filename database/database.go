@@ -4,66 +4,295 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
-	"time"
 )
 
 const DB_PATH string = "database.json"
 
+// compactThreshold is the number of journal commits after which the journal
+// is folded back into the snapshot.
+const compactThreshold = 1000
+
+// DB is the JSON file store. The whole database is held in memory; every
+// change is first appended to a journal next to the snapshot file and the
+// journal is periodically compacted into a fresh snapshot.
 type DB struct {
-	path       string
-	mux        *sync.Mutex
-	chirpCount int
+	path    string
+	mux     *sync.RWMutex
+	data    DBStructure
+	index   indexes
+	journal *journal
+	ids     IDGenerator
 }
 
 type DBStructure struct {
 	Data struct {
-		Users  Users  `json:"users"`
-		Chirps Chirps `json:"chirps"`
+		Users          Users                  `json:"users"`
+		Chirps         Chirps                 `json:"chirps"`
+		RefreshTokens  records[RefreshToken]  `json:"refresh_tokens"`
+		APIKeys        records[APIKey]        `json:"api_keys"`
+		OAuthClients   records[OAuthClient]   `json:"oauth_clients"`
+		OAuthCodes     records[OAuthCode]     `json:"oauth_codes"`
+		Identities     records[Identity]      `json:"identities"`
+		ChirpRevisions records[ChirpRevision] `json:"chirp_revisions"`
+		Likes          records[Like]          `json:"likes"`
+		// Sequences holds the highest ID ever allocated per table so IDs
+		// are never reused, even after the newest record is deleted.
+		Sequences map[string]int `json:"sequences"`
 	} `json:"data"`
 }
 
+// Chirp is a post. EditedAt is when its body last changed, or zero if it
+// never did. Revisions counts the versions of the body, the current one
+// included; the earlier ones are kept as ChirpRevisions. Chirps stored
+// before these were recorded have a zero CreatedAt and Revisions.
+//
+// A reply has the ID of the chirp it answers in InReplyTo and the ID of the
+// chirp that started the conversation in ConversationID. Both are zero for
+// chirps that start a conversation. Replies keep them when the chirps they
+// point to are deleted.
+//
+// A rechirp reposts the chirp in RechirpOf and has no body of its own. An
+// author rechirps a chirp at most once, and rechirps are deleted with the
+// chirp they repost. A quote has a body and the ID of the chirp it quotes
+// in QuoteOf, which it keeps when that chirp is deleted.
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID             int    `json:"id"`
+	Body           string `json:"body"`
+	AuthorID       int    `json:"author_id"`
+	CreatedAt      int64  `json:"created_at,omitempty"`
+	EditedAt       int64  `json:"edited_at,omitempty"`
+	Revisions      int    `json:"revisions,omitempty"`
+	InReplyTo      int    `json:"in_reply_to,omitempty"`
+	ConversationID int    `json:"conversation_id,omitempty"`
+	RechirpOf      int    `json:"rechirp_of,omitempty"`
+	QuoteOf        int    `json:"quote_of,omitempty"`
+}
+
+// rechirpKey is how rechirps are indexed by author and chirp reposted.
+type rechirpKey struct {
+	authorID int
+	chirpID  int
 }
 
 type Chirps struct {
-	Chirps map[int]Chirp `json:"chirps"`
+	Chirps records[Chirp] `json:"chirps"`
 }
 
+// User is an account. A new email address is kept in PendingEmail and only
+// replaces Email once it has been verified.
+//
+// TOTPSecret is set from two-factor enrollment on, TOTPEnabled once the
+// user has proved they can produce codes. TOTPLastStep is the time step of
+// the last accepted code, which may not be used again. RecoveryCodes holds
+// hashes of the unused recovery codes.
+//
+// DeleteAfter is set while the user has asked for their account to be
+// deleted; it is purged once that time has passed.
 type User struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	Password     string `json:"password"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresAt    int64  `json:"expires_at"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	ID            int      `json:"id"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	PendingEmail  string   `json:"pending_email,omitempty"`
+	Password      string   `json:"password"`
+	IsChirpyRed   bool     `json:"is_chirpy_red"`
+	Role          string   `json:"role,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `json:"totp_enabled,omitempty"`
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	DeleteAfter   int64    `json:"delete_after,omitempty"`
 }
 
+// Roles a user can have. Users created before roles existed have an empty
+// Role and are treated as RoleUser.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type Users struct {
-	Users map[int]User
+	Users records[User]
+}
+
+const (
+	usersTable          = "users"
+	chirpsTable         = "chirps"
+	refreshTokensTable  = "refresh_tokens"
+	apiKeysTable        = "api_keys"
+	oauthClientsTable   = "oauth_clients"
+	oauthCodesTable     = "oauth_codes"
+	identitiesTable     = "identities"
+	chirpRevisionsTable = "chirp_revisions"
+	likesTable          = "likes"
+)
+
+// tables maps every journal table name to where its records live.
+func (d *DBStructure) tables() map[string]table {
+	return map[string]table{
+		usersTable:          &d.Data.Users.Users,
+		chirpsTable:         &d.Data.Chirps.Chirps,
+		refreshTokensTable:  &d.Data.RefreshTokens,
+		apiKeysTable:        &d.Data.APIKeys,
+		oauthClientsTable:   &d.Data.OAuthClients,
+		oauthCodesTable:     &d.Data.OAuthCodes,
+		identitiesTable:     &d.Data.Identities,
+		chirpRevisionsTable: &d.Data.ChirpRevisions,
+		likesTable:          &d.Data.Likes,
+	}
+}
+
+func (d *DBStructure) table(name string) (table, error) {
+	t, ok := d.tables()[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown table: %s", name)
+	}
+	return t, nil
+}
+
+func newDBStructure() DBStructure {
+	dbStructure := DBStructure{}
+	dbStructure.fill()
+	return dbStructure
+}
+
+// bumpSequence records id as allocated for table.
+func (d *DBStructure) bumpSequence(table string, id int) {
+	if id > d.Data.Sequences[table] {
+		d.Data.Sequences[table] = id
+	}
+}
+
+// fill creates any table missing from the snapshot and makes sure the
+// sequences cover every stored record, for snapshots written by older
+// versions.
+func (d *DBStructure) fill() {
+	if d.Data.Sequences == nil {
+		d.Data.Sequences = make(map[string]int)
+	}
+	for name, t := range d.tables() {
+		t.init()
+		for _, id := range t.ids() {
+			d.bumpSequence(name, id)
+		}
+	}
+}
+
+// restoreEntry returns an entry that puts record id of table back the way
+// it is now. Transactions keep these to roll back.
+func (d *DBStructure) restoreEntry(name string, id int) (journalEntry, error) {
+	t, err := d.table(name)
+	if err != nil {
+		return journalEntry{}, err
+	}
+	return t.restoreEntry(name, id)
+}
+
+// apply folds a single journal entry into the structure.
+func (d *DBStructure) apply(e journalEntry) error {
+	t, err := d.table(e.Table)
+	if err != nil {
+		return fmt.Errorf("Unknown table in journal: %s", e.Table)
+	}
+	err = t.apply(e)
+	if err != nil {
+		return err
+	}
+	d.bumpSequence(e.Table, e.ID)
+	return nil
+}
+
+// table is the type-independent view of one records map.
+type table interface {
+	init()
+	ids() []int
+	apply(e journalEntry) error
+	restoreEntry(name string, id int) (journalEntry, error)
+}
+
+// records is one table of the JSON store, keyed by ID.
+type records[T any] map[int]T
+
+func (r *records[T]) init() {
+	if *r == nil {
+		*r = make(records[T])
+	}
+}
+
+func (r *records[T]) ids() []int {
+	ids := make([]int, 0, len(*r))
+	for id := range *r {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (r *records[T]) restoreEntry(name string, id int) (journalEntry, error) {
+	v, ok := (*r)[id]
+	if !ok {
+		return deleteEntry(name, id), nil
+	}
+	return putEntry(name, id, v)
+}
+
+func (r *records[T]) apply(e journalEntry) error {
+	if len(e.Value) == 0 || string(e.Value) == "null" {
+		delete(*r, e.ID)
+		return nil
+	}
+	var v T
+	err := json.Unmarshal(e.Value, &v)
+	if err != nil {
+		return fmt.Errorf("Unable to decode %s %d: %s", e.Table, e.ID, err)
+	}
+	(*r)[e.ID] = v
+	return nil
+}
+
+func newDB(path string) *DB {
+	return &DB{
+		path:  path,
+		mux:   &sync.RWMutex{},
+		data:  newDBStructure(),
+		index: newIndexes(),
+		ids:   SequenceIDs{},
+	}
+}
+
+// SetIDGenerator changes how IDs for new records are allocated.
+func (db *DB) SetIDGenerator(g IDGenerator) {
+	defer db.mux.Unlock()
+	db.mux.Lock()
+	db.ids = g
 }
 
 func NewDB(path string) (*DB, error) {
-	mux := sync.Mutex{}
-	db := &DB{path, &mux, 0}
+	db := newDB(path)
 	err := db.ensureDB()
 	if err != nil {
 		log.Println(err)
 		return &DB{}, err
 	}
+	err = db.loadDB()
+	if err != nil {
+		log.Println(err)
+		return &DB{}, err
+	}
 	return db, nil
 
 }
 
 func (db *DB) ensureDB() error {
 	if _, err := os.Stat(db.path); os.IsNotExist(err) {
-		err := os.WriteFile(db.path, []byte(""), 0666)
+		err := writeFileAtomic(db.path, []byte(""), 0666)
 		if err != nil {
 			return fmt.Errorf("Error creating DB: %s", err)
 		}
@@ -73,62 +302,270 @@ func (db *DB) ensureDB() error {
 	return nil
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
+// loadDB reads the snapshot, replays any journal left over from the last
+// run and compacts the result into a fresh snapshot.
+func (db *DB) loadDB() error {
 	defer db.mux.Unlock()
 	db.mux.Lock()
-	dat, err := os.ReadFile(db.path)
+	dbStructure, err := readSnapshotFile(db.path)
 	if err != nil {
-		return DBStructure{}, fmt.Errorf("Unable to read DB file: %s", err)
+		return err
 	}
-	if len(dat) == 0 {
-		dbStructure := DBStructure{}
-		dbStructure.Data.Users.Users = make(map[int]User)
-		dbStructure.Data.Chirps.Chirps = make(map[int]Chirp)
+	db.data = dbStructure
+	db.index.rebuild(db.data)
 
+	j, err := openJournal(journalPath(db.path))
+	if err != nil {
+		return err
+	}
+	err = j.replay(func(entries []journalEntry) error {
+		for _, e := range entries {
+			err := db.apply(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		j.close()
+		return fmt.Errorf("Unable to replay journal: %s", err)
+	}
+	db.journal = j
+	if j.count > 0 {
+		log.Printf("Replayed %d journal entries from %s", j.count, j.path)
+		return db.compact()
+	}
+	return nil
+}
+
+// ReadSnapshot reads the JSON database at path along with its journal
+// without opening it as a store, so nothing is created, truncated or
+// compacted. It is how the import command reads its source.
+func ReadSnapshot(path string) (DBStructure, error) {
+	dbStructure, err := readSnapshotFile(path)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	j, err := openJournalReadOnly(journalPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
 		return dbStructure, nil
 	}
-	dbStructure := DBStructure{}
-	err = json.Unmarshal(dat, &dbStructure)
 	if err != nil {
-		return DBStructure{}, fmt.Errorf("Unable to unmarshal data from DBfile: %s", err)
+		return DBStructure{}, err
+	}
+	defer j.close()
+	err = j.replay(func(entries []journalEntry) error {
+		for _, e := range entries {
+			err := dbStructure.apply(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("Unable to replay journal: %s", err)
 	}
 	return dbStructure, nil
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
+func readSnapshotFile(path string) (DBStructure, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("Unable to read DB file: %s", err)
+	}
+	dbStructure := newDBStructure()
+	if len(dat) != 0 {
+		err = json.Unmarshal(dat, &dbStructure)
+		if err != nil {
+			return DBStructure{}, fmt.Errorf("Unable to unmarshal data from DBfile: %s", err)
+		}
+	}
+	dbStructure.fill()
+	return dbStructure, nil
+}
+
+// Compact writes the in-memory data as a new snapshot and empties the
+// journal.
+func (db *DB) Compact() error {
+	defer db.mux.Unlock()
+	db.mux.Lock()
+	return db.compact()
+}
+
+func (db *DB) compact() error {
+	if db.journal == nil {
+		return nil
+	}
+	err := db.writeDB(db.data)
+	if err != nil {
+		return err
+	}
+	return db.journal.reset()
+}
+
+// Close compacts the journal and releases the files.
+func (db *DB) Close() error {
 	defer db.mux.Unlock()
 	db.mux.Lock()
+	if db.journal == nil {
+		return nil
+	}
+	err := db.compact()
+	closeErr := db.journal.close()
+	db.journal = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (db *DB) writeDB(dbStructure DBStructure) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return fmt.Errorf("Unable to write to DB: %s", err)
 	}
-	err = os.WriteFile(db.path, dat, 0666)
+	err = writeFileAtomic(db.path, dat, 0666)
+	if err != nil {
+		return fmt.Errorf("Unable to write to DB: %s", err)
+	}
+	return nil
+}
+
+// apply folds e into the in-memory data and moves the affected index
+// entries along with it. If e cannot be applied the data is left as it was
+// and so are the indexes.
+func (db *DB) apply(e journalEntry) error {
+	db.index.unindex(&db.data, e.Table, e.ID)
+	err := db.data.apply(e)
+	db.index.reindex(&db.data, e.Table, e.ID)
+	return err
+}
+
+// View runs fn with a read lock held, so fn sees no writes in progress.
+func (db *DB) View(fn func(tx Tx) error) error {
+	defer db.mux.RUnlock()
+	db.mux.RLock()
+	return fn(&dbTx{db: db})
+}
+
+// Update runs fn with the write lock held, so transactions are fully
+// serialized. Changes are applied to the in-memory data as fn makes them and
+// journaled together once it returns nil. If fn fails, panics, or the
+// journal cannot be written, every change is undone.
+func (db *DB) Update(fn func(tx Tx) error) error {
+	defer db.mux.Unlock()
+	db.mux.Lock()
+	tx := &dbTx{db: db, writable: true}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if len(tx.entries) > 0 && db.journal != nil {
+		err = db.journal.append(tx.entries)
+		if err != nil {
+			return err
+		}
+	}
+	committed = true
+
+	if db.journal != nil && db.journal.count >= compactThreshold {
+		err := db.compact()
+		if err != nil {
+			// The changes are already safe in the journal, so a failed
+			// compaction is retried after the next commit.
+			log.Printf("Unable to compact journal: %s", err)
+		}
+	}
 	return nil
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+// dbTx is a transaction on the JSON and memory stores.
+type dbTx struct {
+	db       *DB
+	writable bool
+	entries  []journalEntry
+	undo     []journalEntry
+}
+
+// write applies e straight away and remembers how to undo it.
+func (tx *dbTx) write(e journalEntry) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	undo, err := tx.db.data.restoreEntry(e.Table, e.ID)
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
-	id := db.chirpCount + 1
-	chirp := Chirp{id, body, authorID}
-	dbStructure.Data.Chirps.Chirps[id] = chirp
-	err = db.writeDB(dbStructure)
+	err = tx.db.apply(e)
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
-	db.chirpCount++
-	return chirp, nil
+	tx.entries = append(tx.entries, e)
+	tx.undo = append(tx.undo, undo)
+	return nil
 }
 
-func (db *DB) GetChirps(sortOrder string) ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
+func (tx *dbTx) put(table string, id int, v any) error {
+	e, err := putEntry(table, id, v)
 	if err != nil {
-		return []Chirp{}, err
+		return err
+	}
+	return tx.write(e)
+}
+
+func (tx *dbTx) delete(table string, id int) error {
+	return tx.write(deleteEntry(table, id))
+}
+
+func (tx *dbTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		err := tx.db.apply(tx.undo[i])
+		if err != nil {
+			log.Printf("Unable to roll back %s %d: %s", tx.undo[i].Table, tx.undo[i].ID, err)
+		}
 	}
+	tx.entries = nil
+	tx.undo = nil
+}
+
+// nextID allocates an ID for a new record in table.
+func (tx *dbTx) nextID(table string) (int, error) {
+	if !tx.writable {
+		return 0, ErrReadOnly
+	}
+	return tx.db.ids.NextID(table, tx.db.data.Data.Sequences[table])
+}
+
+func (tx *dbTx) CreateChirp(c Chirp) (Chirp, error) {
+	if c.RechirpOf != 0 {
+		if _, ok := tx.db.index.rechirpByAuthorChirp[rechirpKey{c.AuthorID, c.RechirpOf}]; ok {
+			return Chirp{}, errors.New("Rechirp already exists.")
+		}
+	}
+	id, err := tx.nextID(chirpsTable)
+	if err != nil {
+		return Chirp{}, err
+	}
+	c.ID = id
+	err = tx.put(chirpsTable, id, c)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return c, nil
+}
+
+func (tx *dbTx) GetChirps(sortOrder string) ([]Chirp, error) {
 	chirps := []Chirp{}
-	for _, v := range dbStructure.Data.Chirps.Chirps {
+	for _, v := range tx.db.data.Data.Chirps.Chirps {
 		chirps = append(chirps, v)
 	}
 	if sortOrder == "desc" {
@@ -140,75 +577,98 @@ func (db *DB) GetChirps(sortOrder string) ([]Chirp, error) {
 
 }
 
-func (db *DB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return []Chirp{}, err
+func (tx *dbTx) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
+	ids := tx.db.index.chirpsByAuthor[authorID]
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, tx.db.data.Data.Chirps.Chirps[id])
 	}
-	chirps := []Chirp{}
-	for _, v := range dbStructure.Data.Chirps.Chirps {
-		if v.AuthorID == authorID {
-			chirps = append(chirps, v)
-		}
-	}
-	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
 	return chirps, nil
 
 }
 
-func (db *DB) GetChirp(chirpID int) (Chirp, error) {
-	chirps, err := db.GetChirps("asc")
-	if err != nil {
-		return Chirp{}, err
+func (tx *dbTx) GetChirpReplies(chirpID int) ([]Chirp, error) {
+	ids := tx.db.index.chirpRepliesByParent[chirpID]
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, tx.db.data.Data.Chirps.Chirps[id])
+	}
+	return chirps, nil
+}
+
+func (tx *dbTx) GetRechirp(authorID int, chirpID int) (Chirp, error) {
+	id, ok := tx.db.index.rechirpByAuthorChirp[rechirpKey{authorID, chirpID}]
+	if !ok {
+		return Chirp{}, notFound("Rechirp not found")
 	}
+	return tx.db.data.Data.Chirps.Chirps[id], nil
+}
 
-	if chirpID > len(chirps) {
-		return Chirp{}, fmt.Errorf("Chirp with chirpID %d does not exist.", chirpID)
+func (tx *dbTx) CountRechirps(chirpIDs []int) (map[int]int, error) {
+	counts := make(map[int]int, len(chirpIDs))
+	for _, id := range chirpIDs {
+		counts[id] = len(tx.db.index.rechirpsByChirp[id])
 	}
-	chirp := chirps[chirpID-1]
-	if chirp.ID != chirpID {
-		return Chirp{}, errors.New("Internal error in retrieving chirp.")
+	return counts, nil
+}
+
+func (tx *dbTx) GetChirp(chirpID int) (Chirp, error) {
+	chirp, ok := tx.db.data.Data.Chirps.Chirps[chirpID]
+	if !ok {
+		return Chirp{}, notFound("Chirp with chirpID %d does not exist.", chirpID)
 	}
 
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(chirpID int) error {
-	dbStructure, err := db.loadDB()
+func (tx *dbTx) UpdateChirp(c Chirp) error {
+	if _, ok := tx.db.data.Data.Chirps.Chirps[c.ID]; !ok {
+		return notFound("Chirp with ID %d does not exist.", c.ID)
+	}
+	return tx.put(chirpsTable, c.ID, c)
+}
+
+func (tx *dbTx) DeleteChirp(chirpID int) error {
+	_, ok := tx.db.data.Data.Chirps.Chirps[chirpID]
+	if !ok {
+		return notFound("Chirp with ID %d doees not exist.", chirpID)
+	}
+	for _, id := range slices.Clone(tx.db.index.chirpRevisionsByChirp[chirpID]) {
+		err := tx.delete(chirpRevisionsTable, id)
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.deleteLikes(tx.db.index.likesByChirp[chirpID])
 	if err != nil {
 		return err
 	}
-	chirps := dbStructure.Data.Chirps.Chirps
-	_, ok := chirps[chirpID]
-	if !ok {
-		return fmt.Errorf("Chirp with ID %d doees not exist.", chirpID)
+	for _, id := range slices.Clone(tx.db.index.rechirpsByChirp[chirpID]) {
+		err := tx.DeleteChirp(id)
+		if err != nil {
+			return err
+		}
 	}
-	delete(chirps, chirpID)
-
-	return nil
+	return tx.delete(chirpsTable, chirpID)
 }
 
-func (db *DB) CreateUser(email string, password string) (User, error) {
-	dbStructure, err := db.loadDB()
+func (tx *dbTx) CreateUser(email string, password string) (User, error) {
+	if _, ok := tx.db.index.userByEmail[strings.ToLower(email)]; ok {
+		return User{}, errors.New("User already exists.")
+	}
+	id, err := tx.nextID(usersTable)
 	if err != nil {
 		return User{}, err
 	}
-	users := dbStructure.Data.Users.Users
-	id := len(users) + 1
 
 	user := User{
 		ID:          id,
 		Email:       email,
 		Password:    password,
 		IsChirpyRed: false,
+		Role:        RoleUser,
 	}
-	for _, u := range users {
-		if u.Email == email {
-			return User{}, errors.New("User already exists.")
-		}
-	}
-	dbStructure.Data.Users.Users[id] = user
-	err = db.writeDB(dbStructure)
+	err = tx.put(usersTable, id, user)
 	if err != nil {
 		log.Println(err.Error())
 		return User{}, err
@@ -217,50 +677,32 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 	return user, nil
 }
 
-func (db *DB) GetUser(email string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	users := dbStructure.Data.Users.Users
-	for _, user := range users {
-		if user.Email == email {
-			return user, nil
-		}
+func (tx *dbTx) GetUser(email string) (User, error) {
+	id, ok := tx.db.index.userByEmail[strings.ToLower(email)]
+	if !ok {
+		return User{}, notFound("User not found: %s", email)
 	}
-	return User{}, fmt.Errorf("User not found: %s", email)
+	return tx.db.data.Data.Users.Users[id], nil
 }
 
-func (db *DB) GetUserByID(id int) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	users := dbStructure.Data.Users.Users
-	for _, user := range users {
-		if user.ID == id {
-			return user, nil
-		}
+func (tx *dbTx) GetUserByID(id int) (User, error) {
+	user, ok := tx.db.data.Data.Users.Users[id]
+	if !ok {
+		return User{}, notFound("User not found")
 	}
-	return User{}, errors.New("User not found")
+	return user, nil
 }
 
-func (db *DB) UpdateUser(id int, u User) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	user, ok := dbStructure.Data.Users.Users[id]
+func (tx *dbTx) UpdateUser(id int, u User) (User, error) {
+	user, ok := tx.db.data.Data.Users.Users[id]
 	if !ok {
-		return User{}, err
+		return User{}, notFound("User not found")
 	}
 
 	// user.Email = u.Email
 	// user.Password = u.Password
-	dbStructure.Data.Users.Users[id] = u
-	err = db.writeDB(dbStructure)
+	u.ID = id
+	err := tx.put(usersTable, id, u)
 	if err != nil {
 		return User{}, err
 	}
@@ -268,66 +710,61 @@ func (db *DB) UpdateUser(id int, u User) (User, error) {
 	return user, nil
 }
 
-func (db *DB) UpdateRefreshToken(id int, t string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-	user, ok := dbStructure.Data.Users.Users[id]
-	if !ok {
-		return err
-	}
-	user = User{
-		ID:           user.ID,
-		Email:        user.Email,
-		Password:     user.Password,
-		RefreshToken: t,
-		ExpiresAt:    time.Now().UTC().Add(time.Duration(24) * time.Hour * 60).Unix(),
-	}
-	dbStructure.Data.Users.Users[id] = user
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
+func (tx *dbTx) GetUsersToDelete(now int64) ([]User, error) {
+	users := []User{}
+	for _, u := range tx.db.data.Data.Users.Users {
+		if u.DeleteAfter != 0 && u.DeleteAfter <= now {
+			users = append(users, u)
+		}
 	}
-	return nil
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
 }
 
-func (db *DB) VerifyRefreshToken(t string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+func (tx *dbTx) DeleteUser(id int) error {
+	if _, ok := tx.db.data.Data.Users.Users[id]; !ok {
+		return notFound("User not found")
 	}
-	users := dbStructure.Data.Users.Users
-	for _, u := range users {
-		now := time.Now().Unix()
-		fmt.Printf("Time now: %d\nExpires at: %d\nExpired:%v", now, u.ExpiresAt, now > u.ExpiresAt)
-		if u.RefreshToken == t && u.ExpiresAt > time.Now().Unix() {
-			return u, nil
+	// The index lists shrink as records are deleted, so they are copied
+	// before being walked.
+	for _, tokenID := range slices.Clone(tx.db.index.refreshTokensByUser[id]) {
+		err := tx.delete(refreshTokensTable, tokenID)
+		if err != nil {
+			return err
 		}
 	}
-
-	return User{}, errors.New("Invalid refresh token.")
-
-}
-
-func (db *DB) RevokeRefreshToken(t string) error {
-	dbStructure, err := db.loadDB()
+	for _, keyID := range slices.Clone(tx.db.index.apiKeysByUser[id]) {
+		err := tx.delete(apiKeysTable, keyID)
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.deleteLikes(tx.db.index.likesByUser[id])
 	if err != nil {
 		return err
 	}
-	users := dbStructure.Data.Users.Users
-	for i, u := range users {
-		if u.RefreshToken == t {
-			u.RefreshToken = ""
-			dbStructure.Data.Users.Users[i] = u
-			err := db.writeDB(dbStructure)
-			if err != nil {
-				return err
-			}
-			return nil
+	for _, identityID := range slices.Clone(tx.db.index.identitiesByUser[id]) {
+		err := tx.delete(identitiesTable, identityID)
+		if err != nil {
+			return err
 		}
 	}
-
-	return errors.New("Invalid refresh token.")
-
+	clients := make(map[string]bool)
+	for _, clientID := range slices.Clone(tx.db.index.oauthClientsByOwner[id]) {
+		clients[tx.db.data.Data.OAuthClients[clientID].ClientID] = true
+		err := tx.delete(oauthClientsTable, clientID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range tx.db.data.Data.OAuthCodes {
+		if c.UserID != id && !clients[c.ClientID] {
+			continue
+		}
+		err := tx.delete(oauthCodesTable, c.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.delete(usersTable, id)
 }
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crashedDB returns the path of a JSON database whose last changes are
+// only in the journal, followed by tail, as if the process had crashed.
+func crashedDB(t *testing.T, tail string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		update(t, db, func(tx Tx) error {
+			_, err := tx.CreateUser(email, "hash")
+			return err
+		})
+	}
+	for _, body := range []string{"first", "second"} {
+		update(t, db, func(tx Tx) error {
+			_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: body})
+			return err
+		})
+	}
+	update(t, db, func(tx Tx) error {
+		return tx.DeleteChirp(1)
+	})
+	f, err := os.OpenFile(journalPath(path), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkCrashedDB checks that d holds what crashedDB wrote.
+func checkCrashedDB(t *testing.T, d DBStructure) {
+	t.Helper()
+	if len(d.Data.Users.Users) != 2 {
+		t.Errorf("got %d users, want 2", len(d.Data.Users.Users))
+	}
+	if _, ok := d.Data.Chirps.Chirps[1]; ok {
+		t.Error("deleted chirp is back")
+	}
+	if c := d.Data.Chirps.Chirps[2]; c.Body != "second" {
+		t.Errorf("chirp 2 is %+v", c)
+	}
+}
+
+// journalTails are what a crash may leave at the end of the journal.
+var journalTails = []struct {
+	name    string
+	tail    string
+	wantErr bool
+}{
+	{"clean", "", false},
+	{"torn final line", `[{"table":"users","id":3,"val`, false},
+	{"corrupt line", "not json\n", true},
+}
+
+func TestJournalReplay(t *testing.T) {
+	for _, tt := range journalTails {
+		t.Run(tt.name, func(t *testing.T) {
+			path := crashedDB(t, tt.tail)
+			db, err := NewDB(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewDB succeeded with a corrupt journal")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			checkCrashedDB(t, db.data)
+			info, err := os.Stat(journalPath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != 0 {
+				t.Errorf("journal is %d bytes after replay, want it compacted", info.Size())
+			}
+			snapshot, err := readSnapshotFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCrashedDB(t, snapshot)
+		})
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < compactThreshold; i++ {
+		update(t, db, func(tx Tx) error {
+			_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: "hello"})
+			return err
+		})
+	}
+	info, err := os.Stat(journalPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("journal is %d bytes after %d commits, want it compacted", info.Size(), compactThreshold)
+	}
+	snapshot, err := readSnapshotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Data.Chirps.Chirps) != compactThreshold {
+		t.Errorf("snapshot has %d chirps, want %d", len(snapshot.Data.Chirps.Chirps), compactThreshold)
+	}
+}
+
+func TestReadSnapshotLeavesSourceAlone(t *testing.T) {
+	for _, tt := range journalTails {
+		t.Run(tt.name, func(t *testing.T) {
+			path := crashedDB(t, tt.tail)
+			files := map[string][]byte{}
+			for _, p := range []string{path, journalPath(path)} {
+				dat, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				files[p] = dat
+			}
+
+			snapshot, err := ReadSnapshot(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ReadSnapshot succeeded with a corrupt journal")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				checkCrashedDB(t, snapshot)
+			}
+
+			for p, before := range files {
+				after, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(after) != string(before) {
+					t.Errorf("reading the snapshot changed %s", filepath.Base(p))
+				}
+			}
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(files) {
+				t.Errorf("reading the snapshot left %d files behind, want %d", len(entries), len(files))
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	_, err := ReadSnapshot(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("ReadSnapshot read a file that does not exist")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Error("ReadSnapshot created files for a missing database")
+	}
+}
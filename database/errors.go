@@ -0,0 +1,29 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is matched, via errors.Is, by every error a store returns for
+// a record that does not exist.
+var ErrNotFound = errors.New("Record not found")
+
+// ErrReadOnly is returned when a write is attempted inside View.
+var ErrReadOnly = errors.New("Cannot write in a read-only transaction")
+
+type notFoundError struct {
+	msg string
+}
+
+func (e notFoundError) Error() string {
+	return e.msg
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func notFound(format string, args ...any) error {
+	return notFoundError{fmt.Sprintf(format, args...)}
+}
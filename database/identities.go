@@ -0,0 +1,71 @@
+package database
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, which is known by its issuer and names the account by subject.
+// Email is the address the provider last vouched for.
+type Identity struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	Issuer     string `json:"issuer"`
+	Subject    string `json:"subject"`
+	Email      string `json:"email"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+}
+
+// identityKey is how identities are indexed by issuer and subject.
+func identityKey(issuer string, subject string) string {
+	return issuer + "\x00" + subject
+}
+
+func (tx *dbTx) CreateIdentity(i Identity) (Identity, error) {
+	id, err := tx.nextID(identitiesTable)
+	if err != nil {
+		return Identity{}, err
+	}
+	i.ID = id
+	err = tx.put(identitiesTable, id, i)
+	if err != nil {
+		return Identity{}, err
+	}
+	return i, nil
+}
+
+func (tx *dbTx) GetIdentity(issuer string, subject string) (Identity, error) {
+	id, ok := tx.db.index.identityBySubject[identityKey(issuer, subject)]
+	if !ok {
+		return Identity{}, notFound("Identity not found")
+	}
+	return tx.db.data.Data.Identities[id], nil
+}
+
+func (tx *dbTx) GetIdentityByID(id int) (Identity, error) {
+	i, ok := tx.db.data.Data.Identities[id]
+	if !ok {
+		return Identity{}, notFound("Identity %d not found", id)
+	}
+	return i, nil
+}
+
+func (tx *dbTx) GetIdentitiesByUser(userID int) ([]Identity, error) {
+	ids := tx.db.index.identitiesByUser[userID]
+	identities := make([]Identity, 0, len(ids))
+	for _, id := range ids {
+		identities = append(identities, tx.db.data.Data.Identities[id])
+	}
+	return identities, nil
+}
+
+func (tx *dbTx) UpdateIdentity(i Identity) error {
+	if _, ok := tx.db.data.Data.Identities[i.ID]; !ok {
+		return notFound("Identity %d not found", i.ID)
+	}
+	return tx.put(identitiesTable, i.ID, i)
+}
+
+func (tx *dbTx) DeleteIdentity(id int) error {
+	if _, ok := tx.db.data.Data.Identities[id]; !ok {
+		return notFound("Identity %d not found", id)
+	}
+	return tx.delete(identitiesTable, id)
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator hands out IDs for new records. last is the highest ID the
+// store has ever allocated for the table, so generators can guarantee IDs
+// never go backwards even after deletions or restarts.
+type IDGenerator interface {
+	NextID(table string, last int) (int, error)
+}
+
+// SequenceIDs allocates 1, 2, 3, ... per table. This is the default.
+type SequenceIDs struct{}
+
+func (SequenceIDs) NextID(table string, last int) (int, error) {
+	return last + 1, nil
+}
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// snowflakeEpoch is 2024-01-01T00:00:00Z in milliseconds.
+const snowflakeEpoch int64 = 1704067200000
+
+// SnowflakeIDs allocates time-ordered 63-bit IDs made of a millisecond
+// timestamp, a node number and a per-millisecond counter, so several
+// Chirpy instances can hand out IDs without coordinating. They are larger
+// than 2^53, the biggest integer a double holds exactly, so clients that
+// parse JSON numbers as doubles get them wrong.
+type SnowflakeIDs struct {
+	mux    *sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+}
+
+func NewSnowflakeIDs(node int) (*SnowflakeIDs, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("Snowflake node must be between 0 and %d", snowflakeMaxNode)
+	}
+	return &SnowflakeIDs{mux: &sync.Mutex{}, node: int64(node)}, nil
+}
+
+func (s *SnowflakeIDs) NextID(table string, last int) (int, error) {
+	defer s.mux.Unlock()
+	s.mux.Lock()
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now < s.lastMs {
+		// The clock went backwards; keep using the last timestamp.
+		now = s.lastMs
+	}
+	if now == s.lastMs {
+		s.seq++
+		if s.seq > snowflakeMaxSeq {
+			for now <= s.lastMs {
+				time.Sleep(time.Millisecond)
+				now = time.Now().UnixMilli() - snowflakeEpoch
+			}
+			s.seq = 0
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastMs = now
+	id := int(now<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq)
+	if id <= last {
+		// Never hand out an ID below one already in the store, e.g. after
+		// switching from sequences or a clock reset.
+		id = last + 1
+	}
+	return id, nil
+}
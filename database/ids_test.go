@@ -0,0 +1,79 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIDGenerators(t *testing.T) {
+	snowflake, err := NewSnowflakeIDs(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ids  IDGenerator
+		last int
+	}{
+		{"sequence", SequenceIDs{}, 0},
+		{"sequence after deletions", SequenceIDs{}, 41},
+		{"snowflake", snowflake, 0},
+		// A store switched from sequences may hold IDs above the clock's.
+		{"snowflake above last", snowflake, 1 << 62},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := tt.last
+			for i := 0; i < 5000; i++ {
+				id, err := tt.ids.NextID(usersTable, last)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id <= last {
+					t.Fatalf("NextID returned %d after %d", id, last)
+				}
+				last = id
+			}
+		})
+	}
+
+	_, err = NewSnowflakeIDs(snowflakeMaxNode + 1)
+	if err == nil {
+		t.Error("NewSnowflakeIDs accepted a node number that does not fit")
+	}
+}
+
+func TestSequencesSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second"} {
+		update(t, db, func(tx Tx) error {
+			_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: body})
+			return err
+		})
+	}
+	update(t, db, func(tx Tx) error {
+		return tx.DeleteChirp(2)
+	})
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var chirp Chirp
+	update(t, db, func(tx Tx) error {
+		chirp, err = tx.CreateChirp(Chirp{AuthorID: 1, Body: "third"})
+		return err
+	})
+	if chirp.ID != 3 {
+		t.Errorf("new chirp has ID %d, want 3", chirp.ID)
+	}
+}
@@ -0,0 +1,336 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+// indexes are the secondary lookups kept next to the in-memory data. They
+// are never persisted: the journal apply path keeps them current and they
+// are rebuilt from scratch whenever a snapshot is loaded.
+type indexes struct {
+	// userByEmail is keyed by lower-cased email.
+	userByEmail map[string]int
+	// chirpsByAuthor holds each author's chirp IDs in ascending order.
+	chirpsByAuthor map[int][]int
+	// chirpRepliesByParent holds the IDs of the replies to each chirp in
+	// ascending order.
+	chirpRepliesByParent map[int][]int
+	rechirpByAuthorChirp map[rechirpKey]int
+	// rechirpsByChirp holds the IDs of the rechirps of each chirp in
+	// ascending order.
+	rechirpsByChirp    map[int][]int
+	refreshTokenByHash map[string]int
+	// refreshTokensByUser holds each user's refresh token IDs in ascending
+	// order.
+	refreshTokensByUser map[int][]int
+	apiKeyByHash        map[string]int
+	// apiKeysByUser holds each user's API key IDs in ascending order.
+	apiKeysByUser         map[int][]int
+	oauthClientByClientID map[string]int
+	oauthClientsByOwner   map[int][]int
+	oauthCodeByHash       map[string]int
+	// identityBySubject is keyed by identityKey.
+	identityBySubject map[string]int
+	identitiesByUser  map[int][]int
+	// chirpRevisionsByChirp holds each chirp's revision IDs in ascending
+	// order, which is also the order they were made in.
+	chirpRevisionsByChirp map[int][]int
+	likeByUserChirp       map[likeKey]int
+	// likesByChirp and likesByUser hold like IDs in ascending order.
+	likesByChirp map[int][]int
+	likesByUser  map[int][]int
+}
+
+func newIndexes() indexes {
+	return indexes{
+		userByEmail:           make(map[string]int),
+		chirpsByAuthor:        make(map[int][]int),
+		chirpRepliesByParent:  make(map[int][]int),
+		rechirpByAuthorChirp:  make(map[rechirpKey]int),
+		rechirpsByChirp:       make(map[int][]int),
+		refreshTokenByHash:    make(map[string]int),
+		refreshTokensByUser:   make(map[int][]int),
+		apiKeyByHash:          make(map[string]int),
+		apiKeysByUser:         make(map[int][]int),
+		oauthClientByClientID: make(map[string]int),
+		oauthClientsByOwner:   make(map[int][]int),
+		oauthCodeByHash:       make(map[string]int),
+		identityBySubject:     make(map[string]int),
+		identitiesByUser:      make(map[int][]int),
+		chirpRevisionsByChirp: make(map[int][]int),
+		likeByUserChirp:       make(map[likeKey]int),
+		likesByChirp:          make(map[int][]int),
+		likesByUser:           make(map[int][]int),
+	}
+}
+
+// insertSorted adds id to the ascending list ids unless it is already there.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// removeSorted drops id from the ascending list ids.
+func removeSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+func addToList(lists map[int][]int, key, id int) {
+	lists[key] = insertSorted(lists[key], id)
+}
+
+func removeFromList(lists map[int][]int, key, id int) {
+	ids := removeSorted(lists[key], id)
+	if len(ids) == 0 {
+		delete(lists, key)
+		return
+	}
+	lists[key] = ids
+}
+
+func (ix *indexes) addUser(u User) {
+	ix.userByEmail[strings.ToLower(u.Email)] = u.ID
+}
+
+func (ix *indexes) removeUser(u User) {
+	key := strings.ToLower(u.Email)
+	if ix.userByEmail[key] == u.ID {
+		delete(ix.userByEmail, key)
+	}
+}
+
+func (ix *indexes) addChirp(c Chirp) {
+	addToList(ix.chirpsByAuthor, c.AuthorID, c.ID)
+	if c.InReplyTo != 0 {
+		addToList(ix.chirpRepliesByParent, c.InReplyTo, c.ID)
+	}
+	if c.RechirpOf != 0 {
+		ix.rechirpByAuthorChirp[rechirpKey{c.AuthorID, c.RechirpOf}] = c.ID
+		addToList(ix.rechirpsByChirp, c.RechirpOf, c.ID)
+	}
+}
+
+func (ix *indexes) removeChirp(c Chirp) {
+	removeFromList(ix.chirpsByAuthor, c.AuthorID, c.ID)
+	if c.InReplyTo != 0 {
+		removeFromList(ix.chirpRepliesByParent, c.InReplyTo, c.ID)
+	}
+	if c.RechirpOf != 0 {
+		key := rechirpKey{c.AuthorID, c.RechirpOf}
+		if ix.rechirpByAuthorChirp[key] == c.ID {
+			delete(ix.rechirpByAuthorChirp, key)
+		}
+		removeFromList(ix.rechirpsByChirp, c.RechirpOf, c.ID)
+	}
+}
+
+func (ix *indexes) addRefreshToken(t RefreshToken) {
+	ix.refreshTokenByHash[t.TokenHash] = t.ID
+	addToList(ix.refreshTokensByUser, t.UserID, t.ID)
+}
+
+func (ix *indexes) removeRefreshToken(t RefreshToken) {
+	if ix.refreshTokenByHash[t.TokenHash] == t.ID {
+		delete(ix.refreshTokenByHash, t.TokenHash)
+	}
+	removeFromList(ix.refreshTokensByUser, t.UserID, t.ID)
+}
+
+func (ix *indexes) addAPIKey(k APIKey) {
+	ix.apiKeyByHash[k.KeyHash] = k.ID
+	addToList(ix.apiKeysByUser, k.UserID, k.ID)
+}
+
+func (ix *indexes) removeAPIKey(k APIKey) {
+	if ix.apiKeyByHash[k.KeyHash] == k.ID {
+		delete(ix.apiKeyByHash, k.KeyHash)
+	}
+	removeFromList(ix.apiKeysByUser, k.UserID, k.ID)
+}
+
+func (ix *indexes) addOAuthClient(c OAuthClient) {
+	ix.oauthClientByClientID[c.ClientID] = c.ID
+	addToList(ix.oauthClientsByOwner, c.OwnerID, c.ID)
+}
+
+func (ix *indexes) removeOAuthClient(c OAuthClient) {
+	if ix.oauthClientByClientID[c.ClientID] == c.ID {
+		delete(ix.oauthClientByClientID, c.ClientID)
+	}
+	removeFromList(ix.oauthClientsByOwner, c.OwnerID, c.ID)
+}
+
+func (ix *indexes) addOAuthCode(c OAuthCode) {
+	ix.oauthCodeByHash[c.CodeHash] = c.ID
+}
+
+func (ix *indexes) removeOAuthCode(c OAuthCode) {
+	if ix.oauthCodeByHash[c.CodeHash] == c.ID {
+		delete(ix.oauthCodeByHash, c.CodeHash)
+	}
+}
+
+func (ix *indexes) addIdentity(i Identity) {
+	ix.identityBySubject[identityKey(i.Issuer, i.Subject)] = i.ID
+	addToList(ix.identitiesByUser, i.UserID, i.ID)
+}
+
+func (ix *indexes) removeIdentity(i Identity) {
+	key := identityKey(i.Issuer, i.Subject)
+	if ix.identityBySubject[key] == i.ID {
+		delete(ix.identityBySubject, key)
+	}
+	removeFromList(ix.identitiesByUser, i.UserID, i.ID)
+}
+
+func (ix *indexes) addChirpRevision(r ChirpRevision) {
+	addToList(ix.chirpRevisionsByChirp, r.ChirpID, r.ID)
+}
+
+func (ix *indexes) removeChirpRevision(r ChirpRevision) {
+	removeFromList(ix.chirpRevisionsByChirp, r.ChirpID, r.ID)
+}
+
+func (ix *indexes) addLike(l Like) {
+	ix.likeByUserChirp[likeKey{l.UserID, l.ChirpID}] = l.ID
+	addToList(ix.likesByChirp, l.ChirpID, l.ID)
+	addToList(ix.likesByUser, l.UserID, l.ID)
+}
+
+func (ix *indexes) removeLike(l Like) {
+	key := likeKey{l.UserID, l.ChirpID}
+	if ix.likeByUserChirp[key] == l.ID {
+		delete(ix.likeByUserChirp, key)
+	}
+	removeFromList(ix.likesByChirp, l.ChirpID, l.ID)
+	removeFromList(ix.likesByUser, l.UserID, l.ID)
+}
+
+// rebuild throws away the indexes and recomputes them from d.
+func (ix *indexes) rebuild(d DBStructure) {
+	*ix = newIndexes()
+	for _, u := range d.Data.Users.Users {
+		ix.addUser(u)
+	}
+	for _, c := range d.Data.Chirps.Chirps {
+		ix.addChirp(c)
+	}
+	for _, t := range d.Data.RefreshTokens {
+		ix.addRefreshToken(t)
+	}
+	for _, k := range d.Data.APIKeys {
+		ix.addAPIKey(k)
+	}
+	for _, c := range d.Data.OAuthClients {
+		ix.addOAuthClient(c)
+	}
+	for _, c := range d.Data.OAuthCodes {
+		ix.addOAuthCode(c)
+	}
+	for _, i := range d.Data.Identities {
+		ix.addIdentity(i)
+	}
+	for _, r := range d.Data.ChirpRevisions {
+		ix.addChirpRevision(r)
+	}
+	for _, l := range d.Data.Likes {
+		ix.addLike(l)
+	}
+}
+
+// unindex drops the index entries for the record id of table as it
+// currently is in d.
+func (ix *indexes) unindex(d *DBStructure, table string, id int) {
+	switch table {
+	case usersTable:
+		if u, ok := d.Data.Users.Users[id]; ok {
+			ix.removeUser(u)
+		}
+	case chirpsTable:
+		if c, ok := d.Data.Chirps.Chirps[id]; ok {
+			ix.removeChirp(c)
+		}
+	case refreshTokensTable:
+		if t, ok := d.Data.RefreshTokens[id]; ok {
+			ix.removeRefreshToken(t)
+		}
+	case apiKeysTable:
+		if k, ok := d.Data.APIKeys[id]; ok {
+			ix.removeAPIKey(k)
+		}
+	case oauthClientsTable:
+		if c, ok := d.Data.OAuthClients[id]; ok {
+			ix.removeOAuthClient(c)
+		}
+	case oauthCodesTable:
+		if c, ok := d.Data.OAuthCodes[id]; ok {
+			ix.removeOAuthCode(c)
+		}
+	case identitiesTable:
+		if i, ok := d.Data.Identities[id]; ok {
+			ix.removeIdentity(i)
+		}
+	case chirpRevisionsTable:
+		if r, ok := d.Data.ChirpRevisions[id]; ok {
+			ix.removeChirpRevision(r)
+		}
+	case likesTable:
+		if l, ok := d.Data.Likes[id]; ok {
+			ix.removeLike(l)
+		}
+	}
+}
+
+// reindex adds the index entries for the record id of table as it
+// currently is in d.
+func (ix *indexes) reindex(d *DBStructure, table string, id int) {
+	switch table {
+	case usersTable:
+		if u, ok := d.Data.Users.Users[id]; ok {
+			ix.addUser(u)
+		}
+	case chirpsTable:
+		if c, ok := d.Data.Chirps.Chirps[id]; ok {
+			ix.addChirp(c)
+		}
+	case refreshTokensTable:
+		if t, ok := d.Data.RefreshTokens[id]; ok {
+			ix.addRefreshToken(t)
+		}
+	case apiKeysTable:
+		if k, ok := d.Data.APIKeys[id]; ok {
+			ix.addAPIKey(k)
+		}
+	case oauthClientsTable:
+		if c, ok := d.Data.OAuthClients[id]; ok {
+			ix.addOAuthClient(c)
+		}
+	case oauthCodesTable:
+		if c, ok := d.Data.OAuthCodes[id]; ok {
+			ix.addOAuthCode(c)
+		}
+	case identitiesTable:
+		if i, ok := d.Data.Identities[id]; ok {
+			ix.addIdentity(i)
+		}
+	case chirpRevisionsTable:
+		if r, ok := d.Data.ChirpRevisions[id]; ok {
+			ix.addChirpRevision(r)
+		}
+	case likesTable:
+		if l, ok := d.Data.Likes[id]; ok {
+			ix.addLike(l)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// journalEntry is one change to a single record. A nil Value deletes the
+// record. Entries always carry the full record so replaying a journal that
+// was already folded into the snapshot is harmless.
+type journalEntry struct {
+	Table string          `json:"table"`
+	ID    int             `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func putEntry(table string, id int, v any) (journalEntry, error) {
+	dat, err := json.Marshal(v)
+	if err != nil {
+		return journalEntry{}, fmt.Errorf("Unable to encode %s %d: %s", table, id, err)
+	}
+	return journalEntry{table, id, dat}, nil
+}
+
+func deleteEntry(table string, id int) journalEntry {
+	return journalEntry{Table: table, ID: id}
+}
+
+// journal is the append-only write-ahead log that sits next to the
+// snapshot file. Every line is a JSON array of entries written by one
+// commit, so a commit is either fully replayed or not at all.
+type journal struct {
+	path  string
+	file  *os.File
+	count int
+	// readOnly journals are only replayed; a torn final line is skipped
+	// rather than truncated.
+	readOnly bool
+}
+
+func journalPath(dbPath string) string {
+	return dbPath + ".wal"
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open journal: %s", err)
+	}
+	return &journal{path: path, file: f}, nil
+}
+
+// openJournalReadOnly opens the journal at path for replaying only.
+func openJournalReadOnly(path string) (*journal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open journal: %w", err)
+	}
+	return &journal{path: path, file: f, readOnly: true}, nil
+}
+
+// replay calls fn for every committed line in the journal. A torn final
+// line left by a crash mid-append is discarded; corruption anywhere else is
+// an error.
+func (j *journal) replay(fn func([]journalEntry) error) error {
+	_, err := j.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) != 0 && !j.readOnly {
+				// The last append never finished, drop it.
+				return j.file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read journal: %s", err)
+		}
+		entries := []journalEntry{}
+		err = json.Unmarshal(line, &entries)
+		if err != nil {
+			return fmt.Errorf("Corrupt journal entry at offset %d: %s", offset, err)
+		}
+		err = fn(entries)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		j.count++
+	}
+}
+
+// append writes one commit and waits for it to reach the disk.
+func (j *journal) append(entries []journalEntry) error {
+	dat, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("Unable to encode journal entry: %s", err)
+	}
+	dat = append(dat, '\n')
+	_, err = j.file.Write(dat)
+	if err != nil {
+		return fmt.Errorf("Unable to write journal: %s", err)
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return fmt.Errorf("Unable to sync journal: %s", err)
+	}
+	j.count++
+	return nil
+}
+
+// reset empties the journal once its contents are safely in the snapshot.
+func (j *journal) reset() error {
+	err := j.file.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("Unable to truncate journal: %s", err)
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return fmt.Errorf("Unable to sync journal: %s", err)
+	}
+	j.count = 0
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// writeFileAtomic replaces path with data so that readers, and a crash at any
+// point, see either the old contents or the new ones but never a mix.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"errors"
+	"slices"
+)
+
+// Like records that a user liked a chirp. A user likes a chirp at most
+// once.
+type Like struct {
+	ID        int   `json:"id"`
+	UserID    int   `json:"user_id"`
+	ChirpID   int   `json:"chirp_id"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// likeKey is how likes are indexed by user and chirp.
+type likeKey struct {
+	userID  int
+	chirpID int
+}
+
+func (tx *dbTx) CreateLike(l Like) (Like, error) {
+	if _, ok := tx.db.index.likeByUserChirp[likeKey{l.UserID, l.ChirpID}]; ok {
+		return Like{}, errors.New("Like already exists.")
+	}
+	id, err := tx.nextID(likesTable)
+	if err != nil {
+		return Like{}, err
+	}
+	l.ID = id
+	err = tx.put(likesTable, id, l)
+	if err != nil {
+		return Like{}, err
+	}
+	return l, nil
+}
+
+func (tx *dbTx) GetLike(userID int, chirpID int) (Like, error) {
+	id, ok := tx.db.index.likeByUserChirp[likeKey{userID, chirpID}]
+	if !ok {
+		return Like{}, notFound("Like not found")
+	}
+	return tx.db.data.Data.Likes[id], nil
+}
+
+func (tx *dbTx) GetLikesByUser(userID int) ([]Like, error) {
+	ids := tx.db.index.likesByUser[userID]
+	likes := make([]Like, 0, len(ids))
+	for _, id := range ids {
+		likes = append(likes, tx.db.data.Data.Likes[id])
+	}
+	return likes, nil
+}
+
+func (tx *dbTx) CountLikes(chirpIDs []int) (map[int]int, error) {
+	counts := make(map[int]int, len(chirpIDs))
+	for _, id := range chirpIDs {
+		counts[id] = len(tx.db.index.likesByChirp[id])
+	}
+	return counts, nil
+}
+
+func (tx *dbTx) GetLikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := make(map[int]bool)
+	for _, id := range chirpIDs {
+		if _, ok := tx.db.index.likeByUserChirp[likeKey{userID, id}]; ok {
+			liked[id] = true
+		}
+	}
+	return liked, nil
+}
+
+func (tx *dbTx) DeleteLike(id int) error {
+	if _, ok := tx.db.data.Data.Likes[id]; !ok {
+		return notFound("Like %d not found", id)
+	}
+	return tx.delete(likesTable, id)
+}
+
+// deleteLikes deletes the likes with the given IDs, which may come straight
+// from an index.
+func (tx *dbTx) deleteLikes(ids []int) error {
+	for _, id := range slices.Clone(ids) {
+		err := tx.delete(likesTable, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLikesAndRechirps(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(tx Tx) error
+		// wantErr is whether fn fails and so changes nothing.
+		wantErr      bool
+		wantLikes    int
+		wantRechirps int
+	}{
+		{"nothing", func(tx Tx) error { return nil }, false, 1, 1},
+		{"like again", func(tx Tx) error {
+			_, err := tx.CreateLike(Like{UserID: 2, ChirpID: 1})
+			return err
+		}, true, 1, 1},
+		{"rechirp again", func(tx Tx) error {
+			_, err := tx.CreateChirp(Chirp{AuthorID: 2, RechirpOf: 1})
+			return err
+		}, true, 1, 1},
+		{"another user", func(tx Tx) error {
+			_, err := tx.CreateLike(Like{UserID: 3, ChirpID: 1})
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateChirp(Chirp{AuthorID: 3, RechirpOf: 1})
+			return err
+		}, false, 2, 2},
+		{"unlike", func(tx Tx) error {
+			l, err := tx.GetLike(2, 1)
+			if err != nil {
+				return err
+			}
+			return tx.DeleteLike(l.ID)
+		}, false, 0, 1},
+		{"undo rechirp", func(tx Tx) error {
+			c, err := tx.GetRechirp(2, 1)
+			if err != nil {
+				return err
+			}
+			return tx.DeleteChirp(c.ID)
+		}, false, 1, 0},
+	}
+	for _, s := range stores {
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				db := s.open(t)
+				update(t, db, func(tx Tx) error {
+					_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: "hello"})
+					if err != nil {
+						return err
+					}
+					_, err = tx.CreateLike(Like{UserID: 2, ChirpID: 1})
+					if err != nil {
+						return err
+					}
+					_, err = tx.CreateChirp(Chirp{AuthorID: 2, RechirpOf: 1})
+					return err
+				})
+
+				err := db.Update(tt.fn)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Update returned %v, want error %v", err, tt.wantErr)
+				}
+
+				view(t, db, func(tx Tx) error {
+					likes, err := tx.CountLikes([]int{1})
+					if err != nil {
+						return err
+					}
+					if likes[1] != tt.wantLikes {
+						t.Errorf("chirp has %d likes, want %d", likes[1], tt.wantLikes)
+					}
+					rechirps, err := tx.CountRechirps([]int{1})
+					if err != nil {
+						return err
+					}
+					if rechirps[1] != tt.wantRechirps {
+						t.Errorf("chirp has %d rechirps, want %d", rechirps[1], tt.wantRechirps)
+					}
+					return nil
+				})
+			})
+		}
+	}
+}
+
+func TestDeleteChirpDeletesLikesAndRechirps(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			db := s.open(t)
+			update(t, db, func(tx Tx) error {
+				_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: "hello"})
+				if err != nil {
+					return err
+				}
+				_, err = tx.CreateLike(Like{UserID: 2, ChirpID: 1})
+				if err != nil {
+					return err
+				}
+				_, err = tx.CreateChirp(Chirp{AuthorID: 2, RechirpOf: 1})
+				if err != nil {
+					return err
+				}
+				return tx.DeleteChirp(1)
+			})
+			view(t, db, func(tx Tx) error {
+				if _, err := tx.GetLike(2, 1); !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetLike returned %v, want ErrNotFound", err)
+				}
+				if _, err := tx.GetRechirp(2, 1); !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetRechirp returned %v, want ErrNotFound", err)
+				}
+				likes, err := tx.GetLikesByUser(2)
+				if err != nil {
+					return err
+				}
+				if len(likes) != 0 {
+					t.Errorf("user 2 has %d likes, want 0", len(likes))
+				}
+				chirps, err := tx.GetChirpsByAuthor(2)
+				if err != nil {
+					return err
+				}
+				if len(chirps) != 0 {
+					t.Errorf("user 2 has %d chirps, want 0", len(chirps))
+				}
+				return nil
+			})
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package database
+
+// MemoryStore keeps everything in process memory. It is meant for tests and
+// throwaway local runs; nothing survives a restart. It shares the JSON
+// store's in-memory engine, minus the snapshot file and journal.
+type MemoryStore struct {
+	*DB
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{newDB("")}
+}
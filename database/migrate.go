@@ -0,0 +1,194 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies the versioned SQL files in migrations/ to a SQLite
+// database. Files are named NNNN_description.up.sql / .down.sql and the
+// applied versions are tracked in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create schema_migrations: %s", err)
+	}
+	return &Migrator{db, migrations}, nil
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		prefix, rest, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("Invalid migration file name: %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid migration version in %s", name)
+		}
+		dat, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(dat)
+		} else {
+			m.down = string(dat)
+		}
+	}
+
+	migrations := []migration{}
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("Migration %04d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// Latest returns the highest migration version known to this build.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version returns the highest migration version applied to the database.
+func (m *Migrator) Version() (int, error) {
+	var version int
+	err := m.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read schema version: %s", err)
+	}
+	return version, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	return m.MigrateTo(m.Latest())
+}
+
+// Down rolls back the given number of applied migrations.
+func (m *Migrator) Down(steps int) error {
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	applied := []int{}
+	for _, mig := range m.migrations {
+		if mig.version <= current {
+			applied = append(applied, mig.version)
+		}
+	}
+	target := 0
+	if i := len(applied) - steps - 1; i >= 0 {
+		target = applied[i]
+	}
+	return m.MigrateTo(target)
+}
+
+// MigrateTo moves the schema up or down until target is the newest applied
+// version. Each migration runs in its own transaction.
+func (m *Migrator) MigrateTo(target int) error {
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("Unknown migration version %d", target)
+	}
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if target >= current {
+		for _, mig := range m.migrations {
+			if mig.version > current && mig.version <= target {
+				err := m.apply(mig, mig.up, true)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.version <= current && mig.version > target {
+			err := m.apply(mig, mig.down, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(mig migration, script string, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return fmt.Errorf("Migration %04d_%s failed: %s", mig.version, mig.name, err)
+	}
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, mig.version, time.Now().UTC().Unix())
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, mig.version)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to record migration %04d_%s: %s", mig.version, mig.name, err)
+	}
+	return tx.Commit()
+}
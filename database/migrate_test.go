@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schema describes every table in db except SQLite's own and the
+// migration bookkeeping, in a stable order. Columns are listed by name
+// rather than position, because SQLite can only add a column back at the
+// end of a table when a down migration restores it.
+func schema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	tables := query(t, db, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'
+		ORDER BY name`)
+	lines := []string{}
+	for _, table := range tables {
+		columns := query(t, db, `SELECT name || ' ' || type || ' notnull=' || "notnull" || ' default=' || ifnull(dflt_value, 'NULL') || ' pk=' || pk
+			FROM pragma_table_info(?) ORDER BY name`, table)
+		for _, c := range columns {
+			lines = append(lines, table+"."+c)
+		}
+		// Indexes are described by what they cover, since the names SQLite
+		// gives the ones behind UNIQUE constraints depend on column order.
+		indexes := query(t, db, `SELECT ? || ' index '
+			|| CASE il."unique" WHEN 1 THEN 'unique ' ELSE '' END
+			|| CASE WHEN il.name LIKE 'sqlite_%' THEN '' ELSE il.name || ' ' END
+			|| '(' || (SELECT group_concat(name, ', ') FROM (SELECT name FROM pragma_index_info(il.name) ORDER BY seqno)) || ')'
+			FROM pragma_index_list(?) AS il ORDER BY 1`, table, table)
+		lines = append(lines, indexes...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// query returns the first column of every row the query returns.
+func query(t *testing.T, db *sql.DB, q string, args ...any) []string {
+	t.Helper()
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		err := rows.Scan(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "chirpy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// schemas[v] is the schema with every migration up to v applied.
+	schemas := map[int]string{0: schema(t, db)}
+	for _, mig := range migrator.migrations {
+		err := migrator.MigrateTo(mig.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schemas[mig.version] = schema(t, db)
+	}
+
+	for i := len(migrator.migrations) - 1; i >= 0; i-- {
+		mig := migrator.migrations[i]
+		previous := 0
+		if i > 0 {
+			previous = migrator.migrations[i-1].version
+		}
+		t.Run(mig.name, func(t *testing.T) {
+			err := migrator.MigrateTo(previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schema(t, db); got != schemas[previous] {
+				t.Errorf("down did not undo up:\n%s\nwant:\n%s", got, schemas[previous])
+			}
+			err = migrator.MigrateTo(mig.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schema(t, db); got != schemas[mig.version] {
+				t.Errorf("up after down gave:\n%s\nwant:\n%s", got, schemas[mig.version])
+			}
+			err = migrator.MigrateTo(previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("schema is at version %d after rolling everything back", version)
+	}
+	err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); got != schemas[migrator.Latest()] {
+		t.Errorf("up from scratch gave:\n%s\nwant:\n%s", got, schemas[migrator.Latest()])
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE chirps;
+DROP TABLE users;
@@ -0,0 +1,14 @@
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	refresh_token TEXT NOT NULL DEFAULT '',
+	expires_at INTEGER NOT NULL DEFAULT 0,
+	is_chirpy_red INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE chirps (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	body TEXT NOT NULL,
+	author_id INTEGER NOT NULL
+);
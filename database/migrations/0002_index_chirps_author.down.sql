@@ -0,0 +1,2 @@
+DROP INDEX users_refresh_token;
+DROP INDEX chirps_author_id;
@@ -0,0 +1,2 @@
+CREATE INDEX chirps_author_id ON chirps (author_id, id);
+CREATE INDEX users_refresh_token ON users (refresh_token);
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN refresh_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX users_refresh_token ON users (refresh_token);
+
+DROP TABLE refresh_tokens;
@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	family_id INTEGER NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	replaced_by INTEGER NOT NULL DEFAULT 0,
+	revoked_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id, id);
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+
+DROP INDEX users_refresh_token;
+ALTER TABLE users DROP COLUMN refresh_token;
+ALTER TABLE users DROP COLUMN expires_at;
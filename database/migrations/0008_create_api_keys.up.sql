@@ -0,0 +1,15 @@
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	label TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0,
+	last_used_at INTEGER NOT NULL DEFAULT 0,
+	last_used_ip TEXT NOT NULL DEFAULT '',
+	revoked_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX api_keys_user_id ON api_keys (user_id, id);
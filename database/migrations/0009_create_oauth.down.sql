@@ -0,0 +1,4 @@
+ALTER TABLE refresh_tokens DROP COLUMN scopes;
+ALTER TABLE refresh_tokens DROP COLUMN client_id;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_clients;
@@ -0,0 +1,28 @@
+CREATE TABLE oauth_clients (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	client_id TEXT NOT NULL UNIQUE,
+	owner_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	redirect_uris TEXT NOT NULL,
+	secret_hash TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+
+CREATE INDEX oauth_clients_owner_id ON oauth_clients (owner_id, id);
+
+CREATE TABLE oauth_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code_hash TEXT NOT NULL UNIQUE,
+	client_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	code_challenge TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at INTEGER NOT NULL DEFAULT 0,
+	family_id INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN client_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX users_email_nocase;
+DROP TABLE identities;
@@ -0,0 +1,14 @@
+CREATE TABLE identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	last_used_at INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (issuer, subject)
+);
+
+CREATE INDEX identities_user_id ON identities (user_id, id);
+
+CREATE INDEX users_email_nocase ON users (email COLLATE NOCASE);
@@ -0,0 +1,2 @@
+DROP INDEX users_delete_after;
+ALTER TABLE users DROP COLUMN delete_after;
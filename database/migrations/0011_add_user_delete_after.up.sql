@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN delete_after INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX users_delete_after ON users (delete_after) WHERE delete_after != 0;
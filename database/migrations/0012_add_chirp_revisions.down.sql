@@ -0,0 +1,4 @@
+DROP TABLE chirp_revisions;
+ALTER TABLE chirps DROP COLUMN revisions;
+ALTER TABLE chirps DROP COLUMN edited_at;
+ALTER TABLE chirps DROP COLUMN created_at;
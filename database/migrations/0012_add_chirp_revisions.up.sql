@@ -0,0 +1,12 @@
+ALTER TABLE chirps ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN edited_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN revisions INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE chirp_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chirp_id INTEGER NOT NULL,
+	revision INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	UNIQUE (chirp_id, revision)
+);
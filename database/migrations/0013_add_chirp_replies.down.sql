@@ -0,0 +1,3 @@
+DROP INDEX chirps_in_reply_to;
+ALTER TABLE chirps DROP COLUMN conversation_id;
+ALTER TABLE chirps DROP COLUMN in_reply_to;
@@ -0,0 +1,4 @@
+ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN conversation_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX chirps_in_reply_to ON chirps (in_reply_to, id) WHERE in_reply_to != 0;
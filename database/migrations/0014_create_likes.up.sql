@@ -0,0 +1,9 @@
+CREATE TABLE likes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	chirp_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	UNIQUE (user_id, chirp_id)
+);
+
+CREATE INDEX likes_chirp_id ON likes (chirp_id);
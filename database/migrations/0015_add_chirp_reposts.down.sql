@@ -0,0 +1,3 @@
+DROP INDEX chirps_rechirp_of;
+ALTER TABLE chirps DROP COLUMN quote_of;
+ALTER TABLE chirps DROP COLUMN rechirp_of;
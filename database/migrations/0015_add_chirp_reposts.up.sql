@@ -0,0 +1,4 @@
+ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN quote_of INTEGER NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX chirps_rechirp_of ON chirps (rechirp_of, author_id) WHERE rechirp_of != 0;
@@ -0,0 +1,92 @@
+package database
+
+// OAuthClient is a third-party app registered to act on behalf of Chirpy
+// users. ClientID is public; only a hash of the secret is stored, and
+// public clients such as mobile apps have none.
+type OAuthClient struct {
+	ID           int      `json:"id"`
+	ClientID     string   `json:"client_id"`
+	OwnerID      int      `json:"owner_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	SecretHash   string   `json:"secret_hash"`
+	CreatedAt    int64    `json:"created_at"`
+}
+
+// OAuthCode is an authorization code a user's consent produced, waiting to
+// be exchanged for tokens by the client. Only a hash of the code is stored.
+// RedirectURI is the one the authorization request named, or empty if it
+// left it to the client's only registered one. FamilyID is the refresh
+// token family the exchange started, so it can be revoked if the code is
+// presented again.
+type OAuthCode struct {
+	ID            int      `json:"id"`
+	CodeHash      string   `json:"code_hash"`
+	ClientID      string   `json:"client_id"`
+	UserID        int      `json:"user_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	Scopes        []string `json:"scopes"`
+	CodeChallenge string   `json:"code_challenge"`
+	CreatedAt     int64    `json:"created_at"`
+	ExpiresAt     int64    `json:"expires_at"`
+	UsedAt        int64    `json:"used_at"`
+	FamilyID      int      `json:"family_id"`
+}
+
+func (tx *dbTx) CreateOAuthClient(c OAuthClient) (OAuthClient, error) {
+	id, err := tx.nextID(oauthClientsTable)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	c.ID = id
+	err = tx.put(oauthClientsTable, id, c)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	return c, nil
+}
+
+func (tx *dbTx) GetOAuthClient(clientID string) (OAuthClient, error) {
+	id, ok := tx.db.index.oauthClientByClientID[clientID]
+	if !ok {
+		return OAuthClient{}, notFound("OAuth client %s not found", clientID)
+	}
+	return tx.db.data.Data.OAuthClients[id], nil
+}
+
+func (tx *dbTx) GetOAuthClientsByOwner(ownerID int) ([]OAuthClient, error) {
+	ids := tx.db.index.oauthClientsByOwner[ownerID]
+	clients := make([]OAuthClient, 0, len(ids))
+	for _, id := range ids {
+		clients = append(clients, tx.db.data.Data.OAuthClients[id])
+	}
+	return clients, nil
+}
+
+func (tx *dbTx) CreateOAuthCode(c OAuthCode) (OAuthCode, error) {
+	id, err := tx.nextID(oauthCodesTable)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	c.ID = id
+	err = tx.put(oauthCodesTable, id, c)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	return c, nil
+}
+
+func (tx *dbTx) GetOAuthCode(codeHash string) (OAuthCode, error) {
+	id, ok := tx.db.index.oauthCodeByHash[codeHash]
+	if !ok {
+		return OAuthCode{}, notFound("Authorization code not found")
+	}
+	return tx.db.data.Data.OAuthCodes[id], nil
+}
+
+func (tx *dbTx) UpdateOAuthCode(c OAuthCode) error {
+	if _, ok := tx.db.data.Data.OAuthCodes[c.ID]; !ok {
+		return notFound("Authorization code %d not found", c.ID)
+	}
+	return tx.put(oauthCodesTable, c.ID, c)
+}
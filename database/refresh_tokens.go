@@ -0,0 +1,98 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// RefreshToken is one refresh token issued to one device. Only a hash of
+// the token is stored. Every refresh replaces the token with a new one in
+// the same family; FamilyID is the ID of the first token issued at login,
+// so a whole login session can be revoked at once. Tokens issued to a
+// third-party app carry its ClientID and the Scopes the user granted it.
+type RefreshToken struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	TokenHash string `json:"token_hash"`
+	FamilyID  int    `json:"family_id"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	// ReplacedBy is the ID of the token this one was rotated into, or 0
+	// while it is still the current token of its family.
+	ReplacedBy int      `json:"replaced_by"`
+	RevokedAt  int64    `json:"revoked_at"`
+	ClientID   string   `json:"client_id"`
+	Scopes     []string `json:"scopes"`
+}
+
+// Active reports whether the token may still be exchanged at time now.
+func (t RefreshToken) Active(now time.Time) bool {
+	return t.RevokedAt == 0 && t.ReplacedBy == 0 && t.ExpiresAt > now.Unix()
+}
+
+func (tx *dbTx) CreateRefreshToken(t RefreshToken) (RefreshToken, error) {
+	id, err := tx.nextID(refreshTokensTable)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	t.ID = id
+	if t.FamilyID == 0 {
+		t.FamilyID = id
+	}
+	err = tx.put(refreshTokensTable, id, t)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return t, nil
+}
+
+func (tx *dbTx) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	id, ok := tx.db.index.refreshTokenByHash[tokenHash]
+	if !ok {
+		return RefreshToken{}, notFound("Refresh token not found")
+	}
+	return tx.db.data.Data.RefreshTokens[id], nil
+}
+
+func (tx *dbTx) GetRefreshTokenByID(id int) (RefreshToken, error) {
+	t, ok := tx.db.data.Data.RefreshTokens[id]
+	if !ok {
+		return RefreshToken{}, notFound("Refresh token %d not found", id)
+	}
+	return t, nil
+}
+
+func (tx *dbTx) GetRefreshTokensByUser(userID int) ([]RefreshToken, error) {
+	ids := tx.db.index.refreshTokensByUser[userID]
+	tokens := make([]RefreshToken, 0, len(ids))
+	for _, id := range ids {
+		tokens = append(tokens, tx.db.data.Data.RefreshTokens[id])
+	}
+	return tokens, nil
+}
+
+func (tx *dbTx) UpdateRefreshToken(t RefreshToken) error {
+	if _, ok := tx.db.data.Data.RefreshTokens[t.ID]; !ok {
+		return notFound("Refresh token %d not found", t.ID)
+	}
+	return tx.put(refreshTokensTable, t.ID, t)
+}
+
+func (tx *dbTx) RevokeRefreshTokenFamily(familyID int) error {
+	family := tx.db.data.Data.RefreshTokens[familyID]
+	now := time.Now().UTC().Unix()
+	for _, id := range slices.Clone(tx.db.index.refreshTokensByUser[family.UserID]) {
+		t := tx.db.data.Data.RefreshTokens[id]
+		if t.FamilyID != familyID || t.RevokedAt != 0 {
+			continue
+		}
+		t.RevokedAt = now
+		err := tx.put(refreshTokensTable, id, t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import "testing"
+
+func TestRevokeRefreshTokenFamily(t *testing.T) {
+	tests := []struct {
+		name   string
+		family int
+		// revoked are the hashes of the tokens that end up revoked.
+		revoked map[string]bool
+	}{
+		{"first family", 1, map[string]bool{"1": true, "2": true, "4": true}},
+		{"second family", 3, map[string]bool{"3": true, "5": true}},
+		{"other user", 6, map[string]bool{"6": true}},
+	}
+	for _, s := range stores {
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				db := s.open(t)
+				update(t, db, func(tx Tx) error {
+					// Two interleaved families of user 1, then one of user 2.
+					for _, email := range []string{"a@example.com", "b@example.com"} {
+						_, err := tx.CreateUser(email, "hash")
+						if err != nil {
+							return err
+						}
+					}
+					for _, token := range []RefreshToken{
+						{UserID: 1, TokenHash: "1"},
+						{UserID: 1, TokenHash: "2", FamilyID: 1},
+						{UserID: 1, TokenHash: "3"},
+						{UserID: 1, TokenHash: "4", FamilyID: 1},
+						{UserID: 1, TokenHash: "5", FamilyID: 3},
+						{UserID: 2, TokenHash: "6"},
+					} {
+						_, err := tx.CreateRefreshToken(token)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+
+				update(t, db, func(tx Tx) error {
+					return tx.RevokeRefreshTokenFamily(tt.family)
+				})
+
+				view(t, db, func(tx Tx) error {
+					for _, hash := range []string{"1", "2", "3", "4", "5", "6"} {
+						token, err := tx.GetRefreshToken(hash)
+						if err != nil {
+							return err
+						}
+						if got := token.RevokedAt != 0; got != tt.revoked[hash] {
+							t.Errorf("token %s revoked = %v, want %v", hash, got, tt.revoked[hash])
+						}
+					}
+					tokens, err := tx.GetRefreshTokensByUser(1)
+					if err != nil {
+						return err
+					}
+					if len(tokens) != 5 {
+						t.Errorf("user 1 has %d tokens, want 5", len(tokens))
+					}
+					return nil
+				})
+			})
+		}
+	}
+}
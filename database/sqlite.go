@@ -0,0 +1,511 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps users and chirps in an embedded SQLite database. Unlike
+// the JSON file it only touches the rows a call needs.
+type SQLiteStore struct {
+	db  *sql.DB
+	ids IDGenerator
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// OpenSQLite opens the database file at path without touching its schema.
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open SQLite database: %s", err)
+	}
+	// SQLite only allows one writer at a time; serialising connections
+	// avoids SQLITE_BUSY errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Unable to open SQLite database: %s", err)
+	}
+	return db, nil
+}
+
+// NewSQLiteStore opens the database at path and applies any pending
+// migrations before returning.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	err = migrator.Up()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db, SequenceIDs{}}, nil
+}
+
+// SetIDGenerator changes how IDs for new rows are allocated. With the
+// default SequenceIDs the allocation is left to SQLite's AUTOINCREMENT.
+func (s *SQLiteStore) SetIDGenerator(g IDGenerator) {
+	s.ids = g
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) View(fn func(tx Tx) error) error {
+	return s.run(fn, true)
+}
+
+// Update runs fn in a SQLite transaction that is committed if fn returns
+// nil and rolled back otherwise. The store uses a single connection, so
+// transactions never interleave.
+func (s *SQLiteStore) Update(fn func(tx Tx) error) error {
+	return s.run(fn, false)
+}
+
+func (s *SQLiteStore) run(fn func(tx Tx) error, readOnly bool) error {
+	sqlTx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+	if readOnly {
+		// query_only belongs to the connection, so it is switched back off
+		// on the same connection before the transaction is rolled back.
+		_, err = sqlTx.Exec(`PRAGMA query_only = ON`)
+		if err != nil {
+			return err
+		}
+		defer sqlTx.Exec(`PRAGMA query_only = OFF`)
+	}
+
+	err = fn(&sqliteTx{s, sqlTx})
+	if err != nil || readOnly {
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+// sqliteTx is a transaction on the SQLite store.
+type sqliteTx struct {
+	s  *SQLiteStore
+	tx *sql.Tx
+}
+
+var _ Tx = (*sqliteTx)(nil)
+
+// nextID returns an explicit ID for a new row in table, or 0 to let
+// AUTOINCREMENT pick one.
+func (tx *sqliteTx) nextID(table string) (int, error) {
+	if _, ok := tx.s.ids.(SequenceIDs); ok {
+		return 0, nil
+	}
+	var last int
+	err := tx.tx.QueryRow(`SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = ?), 0)`, table).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+	return tx.s.ids.NextID(table, last)
+}
+
+// Import copies the contents of a JSON database snapshot into the store,
+// keeping the original IDs. It runs in a single transaction.
+func (s *SQLiteStore) Import(dbStructure DBStructure) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, u := range dbStructure.Data.Users.Users {
+		role := u.Role
+		if role == "" {
+			role = RoleUser
+		}
+		_, err := tx.Exec(`INSERT INTO users (id, email, email_verified, pending_email, password, is_chirpy_red, role, scopes,
+			totp_secret, totp_enabled, totp_last_step, recovery_codes, delete_after) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.ID, u.Email, u.EmailVerified, u.PendingEmail, u.Password, u.IsChirpyRed, role, strings.Join(u.Scopes, " "),
+			u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep, strings.Join(u.RecoveryCodes, " "), u.DeleteAfter)
+		if err != nil {
+			return fmt.Errorf("Unable to import user %d: %s", u.ID, err)
+		}
+	}
+	for _, c := range dbStructure.Data.Chirps.Chirps {
+		err := insertChirp(tx, c)
+		if err != nil {
+			return fmt.Errorf("Unable to import chirp %d: %s", c.ID, err)
+		}
+	}
+	for _, t := range dbStructure.Data.RefreshTokens {
+		err := insertRefreshToken(tx, t)
+		if err != nil {
+			return fmt.Errorf("Unable to import refresh token %d: %s", t.ID, err)
+		}
+	}
+	for _, k := range dbStructure.Data.APIKeys {
+		err := insertAPIKey(tx, k)
+		if err != nil {
+			return fmt.Errorf("Unable to import API key %d: %s", k.ID, err)
+		}
+	}
+	for _, c := range dbStructure.Data.OAuthClients {
+		err := insertOAuthClient(tx, c)
+		if err != nil {
+			return fmt.Errorf("Unable to import OAuth client %d: %s", c.ID, err)
+		}
+	}
+	for _, c := range dbStructure.Data.OAuthCodes {
+		err := insertOAuthCode(tx, c)
+		if err != nil {
+			return fmt.Errorf("Unable to import authorization code %d: %s", c.ID, err)
+		}
+	}
+	for _, i := range dbStructure.Data.Identities {
+		err := insertIdentity(tx, i)
+		if err != nil {
+			return fmt.Errorf("Unable to import identity %d: %s", i.ID, err)
+		}
+	}
+	for _, r := range dbStructure.Data.ChirpRevisions {
+		err := insertChirpRevision(tx, r)
+		if err != nil {
+			return fmt.Errorf("Unable to import chirp revision %d: %s", r.ID, err)
+		}
+	}
+	for _, l := range dbStructure.Data.Likes {
+		err := insertLike(tx, l)
+		if err != nil {
+			return fmt.Errorf("Unable to import like %d: %s", l.ID, err)
+		}
+	}
+	// Carry over the high-water marks so IDs of records deleted from the
+	// JSON store are not handed out again.
+	// Every JSON table has a SQL table of the same name.
+	tables := dbStructure.tables()
+	for table, last := range dbStructure.Data.Sequences {
+		if _, ok := tables[table]; !ok {
+			continue
+		}
+		_, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = ? AND seq < ?`, table, last)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO sqlite_sequence (name, seq)
+			SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = ?)`, table, last, table)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const userColumns = `id, email, email_verified, pending_email, password, is_chirpy_red, role, scopes,
+	totp_secret, totp_enabled, totp_last_step, recovery_codes, delete_after`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (User, error) {
+	u := User{}
+	var scopes, recoveryCodes string
+	err := row.Scan(&u.ID, &u.Email, &u.EmailVerified, &u.PendingEmail, &u.Password, &u.IsChirpyRed, &u.Role, &scopes,
+		&u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &recoveryCodes, &u.DeleteAfter)
+	// Scopes are stored space separated, as in an OAuth scope string, and
+	// so are the recovery code hashes.
+	u.Scopes = strings.Fields(scopes)
+	u.RecoveryCodes = strings.Fields(recoveryCodes)
+	return u, err
+}
+
+const chirpColumns = `id, body, author_id, created_at, edited_at, revisions, in_reply_to, conversation_id, rechirp_of, quote_of`
+
+func scanChirp(row scanner) (Chirp, error) {
+	c := Chirp{}
+	err := row.Scan(&c.ID, &c.Body, &c.AuthorID, &c.CreatedAt, &c.EditedAt, &c.Revisions, &c.InReplyTo, &c.ConversationID,
+		&c.RechirpOf, &c.QuoteOf)
+	return c, err
+}
+
+func scanChirps(rows *sql.Rows) ([]Chirp, error) {
+	defer rows.Close()
+	chirps := []Chirp{}
+	for rows.Next() {
+		c, err := scanChirp(rows)
+		if err != nil {
+			return []Chirp{}, err
+		}
+		chirps = append(chirps, c)
+	}
+	return chirps, rows.Err()
+}
+
+func insertChirp(tx *sql.Tx, c Chirp) error {
+	_, err := tx.Exec(`INSERT INTO chirps (`+chirpColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Body, c.AuthorID, c.CreatedAt, c.EditedAt, c.Revisions, c.InReplyTo, c.ConversationID, c.RechirpOf, c.QuoteOf)
+	return err
+}
+
+func (tx *sqliteTx) CreateChirp(c Chirp) (Chirp, error) {
+	if c.RechirpOf != 0 {
+		var exists bool
+		err := tx.tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE rechirp_of = ? AND author_id = ?)`,
+			c.RechirpOf, c.AuthorID).Scan(&exists)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if exists {
+			return Chirp{}, errors.New("Rechirp already exists.")
+		}
+	}
+	chirpID, err := tx.nextID(chirpsTable)
+	if err != nil {
+		return Chirp{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO chirps (`+chirpColumns+`) VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chirpID, c.Body, c.AuthorID, c.CreatedAt, c.EditedAt, c.Revisions, c.InReplyTo, c.ConversationID, c.RechirpOf, c.QuoteOf)
+	if err != nil {
+		return Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	c.ID = int(id)
+	return c, nil
+}
+
+func (tx *sqliteTx) GetChirps(sortOrder string) ([]Chirp, error) {
+	query := `SELECT ` + chirpColumns + ` FROM chirps ORDER BY id ASC`
+	if sortOrder == "desc" {
+		query = `SELECT ` + chirpColumns + ` FROM chirps ORDER BY id DESC`
+	}
+	rows, err := tx.tx.Query(query)
+	if err != nil {
+		return []Chirp{}, err
+	}
+	return scanChirps(rows)
+}
+
+func (tx *sqliteTx) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
+	rows, err := tx.tx.Query(`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? ORDER BY id ASC`, authorID)
+	if err != nil {
+		return []Chirp{}, err
+	}
+	return scanChirps(rows)
+}
+
+func (tx *sqliteTx) GetChirpReplies(chirpID int) ([]Chirp, error) {
+	rows, err := tx.tx.Query(`SELECT `+chirpColumns+` FROM chirps WHERE in_reply_to = ? ORDER BY id ASC`, chirpID)
+	if err != nil {
+		return []Chirp{}, err
+	}
+	return scanChirps(rows)
+}
+
+func (tx *sqliteTx) GetRechirp(authorID int, chirpID int) (Chirp, error) {
+	c, err := scanChirp(tx.tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE rechirp_of = ? AND author_id = ?`, chirpID, authorID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, notFound("Rechirp not found")
+	}
+	return c, err
+}
+
+func (tx *sqliteTx) CountRechirps(chirpIDs []int) (map[int]int, error) {
+	ids, err := json.Marshal(chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.tx.Query(`SELECT rechirp_of, COUNT(*) FROM chirps
+		WHERE rechirp_of IN (SELECT value FROM json_each(?)) GROUP BY rechirp_of`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[int]int, len(chirpIDs))
+	for rows.Next() {
+		var id, n int
+		err := rows.Scan(&id, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+func (tx *sqliteTx) GetChirp(chirpID int) (Chirp, error) {
+	c, err := scanChirp(tx.tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, notFound("Chirp with chirpID %d does not exist.", chirpID)
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return c, nil
+}
+
+func (tx *sqliteTx) UpdateChirp(c Chirp) error {
+	res, err := tx.tx.Exec(`UPDATE chirps SET body = ?, author_id = ?, created_at = ?, edited_at = ?, revisions = ?,
+		in_reply_to = ?, conversation_id = ?, rechirp_of = ?, quote_of = ? WHERE id = ?`,
+		c.Body, c.AuthorID, c.CreatedAt, c.EditedAt, c.Revisions, c.InReplyTo, c.ConversationID, c.RechirpOf, c.QuoteOf, c.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Chirp with ID %d does not exist.", c.ID)
+	}
+	return nil
+}
+
+func (tx *sqliteTx) DeleteChirp(chirpID int) error {
+	for _, query := range []string{
+		`DELETE FROM chirp_revisions WHERE chirp_id = ?`,
+		`DELETE FROM likes WHERE chirp_id = ?`,
+		`DELETE FROM chirp_revisions WHERE chirp_id IN (SELECT id FROM chirps WHERE rechirp_of = ?)`,
+		`DELETE FROM likes WHERE chirp_id IN (SELECT id FROM chirps WHERE rechirp_of = ?)`,
+		`DELETE FROM chirps WHERE rechirp_of = ?`,
+	} {
+		_, err := tx.tx.Exec(query, chirpID)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := tx.tx.Exec(`DELETE FROM chirps WHERE id = ?`, chirpID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Chirp with ID %d doees not exist.", chirpID)
+	}
+	return nil
+}
+
+func (tx *sqliteTx) CreateUser(email string, password string) (User, error) {
+	var exists bool
+	err := tx.tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)`, email).Scan(&exists)
+	if err != nil {
+		return User{}, err
+	}
+	if exists {
+		return User{}, errors.New("User already exists.")
+	}
+	userID, err := tx.nextID(usersTable)
+	if err != nil {
+		return User{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO users (id, email, password) VALUES (NULLIF(?, 0), ?, ?)`, userID, email, password)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return User{
+		ID:          int(id),
+		Email:       email,
+		Password:    password,
+		IsChirpyRed: false,
+		Role:        RoleUser,
+	}, nil
+}
+
+func (tx *sqliteTx) GetUser(email string) (User, error) {
+	user, err := scanUser(tx.tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE`, email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, notFound("User not found: %s", email)
+	}
+	return user, err
+}
+
+func (tx *sqliteTx) GetUserByID(id int) (User, error) {
+	user, err := scanUser(tx.tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, notFound("User not found")
+	}
+	return user, err
+}
+
+func (tx *sqliteTx) UpdateUser(id int, u User) (User, error) {
+	user, err := tx.GetUserByID(id)
+	if err != nil {
+		return User{}, err
+	}
+	_, err = tx.tx.Exec(`UPDATE users SET email = ?, email_verified = ?, pending_email = ?, password = ?, is_chirpy_red = ?, role = ?, scopes = ?,
+		totp_secret = ?, totp_enabled = ?, totp_last_step = ?, recovery_codes = ?, delete_after = ? WHERE id = ?`,
+		u.Email, u.EmailVerified, u.PendingEmail, u.Password, u.IsChirpyRed, u.Role, strings.Join(u.Scopes, " "),
+		u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep, strings.Join(u.RecoveryCodes, " "), u.DeleteAfter, id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (tx *sqliteTx) GetUsersToDelete(now int64) ([]User, error) {
+	rows, err := tx.tx.Query(`SELECT `+userColumns+` FROM users WHERE delete_after != 0 AND delete_after <= ? ORDER BY id ASC`, now)
+	if err != nil {
+		return []User{}, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return []User{}, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (tx *sqliteTx) DeleteUser(id int) error {
+	for _, query := range []string{
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM likes WHERE user_id = ?`,
+		`DELETE FROM identities WHERE user_id = ?`,
+		`DELETE FROM oauth_codes WHERE user_id = ?1 OR client_id IN (SELECT client_id FROM oauth_clients WHERE owner_id = ?1)`,
+		`DELETE FROM oauth_clients WHERE owner_id = ?`,
+	} {
+		_, err := tx.tx.Exec(query, id)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := tx.tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("User not found")
+	}
+	return nil
+}
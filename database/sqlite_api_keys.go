@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const apiKeyColumns = `id, user_id, label, prefix, key_hash, scopes, created_at, expires_at, last_used_at, last_used_ip, revoked_at`
+
+func scanAPIKey(row scanner) (APIKey, error) {
+	k := APIKey{}
+	var scopes string
+	err := row.Scan(&k.ID, &k.UserID, &k.Label, &k.Prefix, &k.KeyHash, &scopes,
+		&k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP, &k.RevokedAt)
+	k.Scopes = strings.Fields(scopes)
+	return k, err
+}
+
+func insertAPIKey(tx *sql.Tx, k APIKey) error {
+	_, err := tx.Exec(`INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.UserID, k.Label, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " "),
+		k.CreatedAt, k.ExpiresAt, k.LastUsedAt, k.LastUsedIP, k.RevokedAt)
+	return err
+}
+
+func (tx *sqliteTx) CreateAPIKey(k APIKey) (APIKey, error) {
+	id, err := tx.nextID(apiKeysTable)
+	if err != nil {
+		return APIKey{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO api_keys (id, user_id, label, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?)`,
+		id, k.UserID, k.Label, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " "), k.CreatedAt, k.ExpiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.ID = int(newID)
+	return k, nil
+}
+
+func (tx *sqliteTx) GetAPIKey(keyHash string) (APIKey, error) {
+	k, err := scanAPIKey(tx.tx.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, notFound("API key not found")
+	}
+	return k, err
+}
+
+func (tx *sqliteTx) GetAPIKeyByID(id int) (APIKey, error) {
+	k, err := scanAPIKey(tx.tx.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, notFound("API key %d not found", id)
+	}
+	return k, err
+}
+
+func (tx *sqliteTx) GetAPIKeysByUser(userID int) ([]APIKey, error) {
+	rows, err := tx.tx.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY id ASC`, userID)
+	if err != nil {
+		return []APIKey{}, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return []APIKey{}, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (tx *sqliteTx) UpdateAPIKey(k APIKey) error {
+	res, err := tx.tx.Exec(`UPDATE api_keys SET user_id = ?, label = ?, prefix = ?, key_hash = ?, scopes = ?,
+		created_at = ?, expires_at = ?, last_used_at = ?, last_used_ip = ?, revoked_at = ? WHERE id = ?`,
+		k.UserID, k.Label, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " "),
+		k.CreatedAt, k.ExpiresAt, k.LastUsedAt, k.LastUsedIP, k.RevokedAt, k.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("API key %d not found", k.ID)
+	}
+	return nil
+}
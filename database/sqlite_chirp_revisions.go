@@ -0,0 +1,53 @@
+package database
+
+import "database/sql"
+
+const chirpRevisionColumns = `id, chirp_id, revision, body, created_at`
+
+func scanChirpRevision(row scanner) (ChirpRevision, error) {
+	r := ChirpRevision{}
+	err := row.Scan(&r.ID, &r.ChirpID, &r.Revision, &r.Body, &r.CreatedAt)
+	return r, err
+}
+
+func insertChirpRevision(tx *sql.Tx, r ChirpRevision) error {
+	_, err := tx.Exec(`INSERT INTO chirp_revisions (`+chirpRevisionColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		r.ID, r.ChirpID, r.Revision, r.Body, r.CreatedAt)
+	return err
+}
+
+func (tx *sqliteTx) CreateChirpRevision(r ChirpRevision) (ChirpRevision, error) {
+	id, err := tx.nextID(chirpRevisionsTable)
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO chirp_revisions (id, chirp_id, revision, body, created_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?)`,
+		id, r.ChirpID, r.Revision, r.Body, r.CreatedAt)
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return ChirpRevision{}, err
+	}
+	r.ID = int(newID)
+	return r, nil
+}
+
+func (tx *sqliteTx) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	rows, err := tx.tx.Query(`SELECT `+chirpRevisionColumns+` FROM chirp_revisions WHERE chirp_id = ? ORDER BY id ASC`, chirpID)
+	if err != nil {
+		return []ChirpRevision{}, err
+	}
+	defer rows.Close()
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		r, err := scanChirpRevision(rows)
+		if err != nil {
+			return []ChirpRevision{}, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+const identityColumns = `id, user_id, issuer, subject, email, created_at, last_used_at`
+
+func scanIdentity(row scanner) (Identity, error) {
+	i := Identity{}
+	err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &i.LastUsedAt)
+	return i, err
+}
+
+func insertIdentity(tx *sql.Tx, i Identity) error {
+	_, err := tx.Exec(`INSERT INTO identities (`+identityColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		i.ID, i.UserID, i.Issuer, i.Subject, i.Email, i.CreatedAt, i.LastUsedAt)
+	return err
+}
+
+func (tx *sqliteTx) CreateIdentity(i Identity) (Identity, error) {
+	id, err := tx.nextID(identitiesTable)
+	if err != nil {
+		return Identity{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO identities (id, user_id, issuer, subject, email, created_at, last_used_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?)`,
+		id, i.UserID, i.Issuer, i.Subject, i.Email, i.CreatedAt, i.LastUsedAt)
+	if err != nil {
+		return Identity{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return Identity{}, err
+	}
+	i.ID = int(newID)
+	return i, nil
+}
+
+func (tx *sqliteTx) GetIdentity(issuer string, subject string) (Identity, error) {
+	i, err := scanIdentity(tx.tx.QueryRow(`SELECT `+identityColumns+` FROM identities WHERE issuer = ? AND subject = ?`, issuer, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, notFound("Identity not found")
+	}
+	return i, err
+}
+
+func (tx *sqliteTx) GetIdentityByID(id int) (Identity, error) {
+	i, err := scanIdentity(tx.tx.QueryRow(`SELECT `+identityColumns+` FROM identities WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, notFound("Identity %d not found", id)
+	}
+	return i, err
+}
+
+func (tx *sqliteTx) GetIdentitiesByUser(userID int) ([]Identity, error) {
+	rows, err := tx.tx.Query(`SELECT `+identityColumns+` FROM identities WHERE user_id = ? ORDER BY id ASC`, userID)
+	if err != nil {
+		return []Identity{}, err
+	}
+	defer rows.Close()
+	identities := []Identity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return []Identity{}, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (tx *sqliteTx) UpdateIdentity(i Identity) error {
+	res, err := tx.tx.Exec(`UPDATE identities SET user_id = ?, issuer = ?, subject = ?, email = ?, created_at = ?, last_used_at = ?
+		WHERE id = ?`,
+		i.UserID, i.Issuer, i.Subject, i.Email, i.CreatedAt, i.LastUsedAt, i.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Identity %d not found", i.ID)
+	}
+	return nil
+}
+
+func (tx *sqliteTx) DeleteIdentity(id int) error {
+	res, err := tx.tx.Exec(`DELETE FROM identities WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Identity %d not found", id)
+	}
+	return nil
+}
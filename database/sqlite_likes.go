@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+const likeColumns = `id, user_id, chirp_id, created_at`
+
+func scanLike(row scanner) (Like, error) {
+	l := Like{}
+	err := row.Scan(&l.ID, &l.UserID, &l.ChirpID, &l.CreatedAt)
+	return l, err
+}
+
+func insertLike(tx *sql.Tx, l Like) error {
+	_, err := tx.Exec(`INSERT INTO likes (`+likeColumns+`) VALUES (?, ?, ?, ?)`,
+		l.ID, l.UserID, l.ChirpID, l.CreatedAt)
+	return err
+}
+
+func (tx *sqliteTx) CreateLike(l Like) (Like, error) {
+	var exists bool
+	err := tx.tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM likes WHERE user_id = ? AND chirp_id = ?)`, l.UserID, l.ChirpID).Scan(&exists)
+	if err != nil {
+		return Like{}, err
+	}
+	if exists {
+		return Like{}, errors.New("Like already exists.")
+	}
+	id, err := tx.nextID(likesTable)
+	if err != nil {
+		return Like{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO likes (id, user_id, chirp_id, created_at) VALUES (NULLIF(?, 0), ?, ?, ?)`,
+		id, l.UserID, l.ChirpID, l.CreatedAt)
+	if err != nil {
+		return Like{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return Like{}, err
+	}
+	l.ID = int(newID)
+	return l, nil
+}
+
+func (tx *sqliteTx) GetLike(userID int, chirpID int) (Like, error) {
+	l, err := scanLike(tx.tx.QueryRow(`SELECT `+likeColumns+` FROM likes WHERE user_id = ? AND chirp_id = ?`, userID, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Like{}, notFound("Like not found")
+	}
+	return l, err
+}
+
+func (tx *sqliteTx) GetLikesByUser(userID int) ([]Like, error) {
+	rows, err := tx.tx.Query(`SELECT `+likeColumns+` FROM likes WHERE user_id = ? ORDER BY id ASC`, userID)
+	if err != nil {
+		return []Like{}, err
+	}
+	defer rows.Close()
+	likes := []Like{}
+	for rows.Next() {
+		l, err := scanLike(rows)
+		if err != nil {
+			return []Like{}, err
+		}
+		likes = append(likes, l)
+	}
+	return likes, rows.Err()
+}
+
+// The chirp IDs of CountLikes and GetLikedChirps are passed as one JSON
+// array, so there is no limit on how many there are.
+
+func (tx *sqliteTx) CountLikes(chirpIDs []int) (map[int]int, error) {
+	ids, err := json.Marshal(chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.tx.Query(`SELECT chirp_id, COUNT(*) FROM likes
+		WHERE chirp_id IN (SELECT value FROM json_each(?)) GROUP BY chirp_id`, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[int]int, len(chirpIDs))
+	for _, id := range chirpIDs {
+		counts[id] = 0
+	}
+	for rows.Next() {
+		var id, n int
+		err := rows.Scan(&id, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+func (tx *sqliteTx) GetLikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	ids, err := json.Marshal(chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.tx.Query(`SELECT chirp_id FROM likes
+		WHERE user_id = ? AND chirp_id IN (SELECT value FROM json_each(?))`, userID, string(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	liked := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		liked[id] = true
+	}
+	return liked, rows.Err()
+}
+
+func (tx *sqliteTx) DeleteLike(id int) error {
+	res, err := tx.tx.Exec(`DELETE FROM likes WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Like %d not found", id)
+	}
+	return nil
+}
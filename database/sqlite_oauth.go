@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const oauthClientColumns = `id, client_id, owner_id, name, redirect_uris, secret_hash, created_at`
+
+const oauthCodeColumns = `id, code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, created_at, expires_at, used_at, family_id`
+
+func scanOAuthClient(row scanner) (OAuthClient, error) {
+	c := OAuthClient{}
+	var redirectURIs string
+	err := row.Scan(&c.ID, &c.ClientID, &c.OwnerID, &c.Name, &redirectURIs, &c.SecretHash, &c.CreatedAt)
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	return c, err
+}
+
+func insertOAuthClient(tx *sql.Tx, c OAuthClient) error {
+	_, err := tx.Exec(`INSERT INTO oauth_clients (`+oauthClientColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.ClientID, c.OwnerID, c.Name, strings.Join(c.RedirectURIs, " "), c.SecretHash, c.CreatedAt)
+	return err
+}
+
+func scanOAuthCode(row scanner) (OAuthCode, error) {
+	c := OAuthCode{}
+	var scopes string
+	err := row.Scan(&c.ID, &c.CodeHash, &c.ClientID, &c.UserID, &c.RedirectURI, &scopes, &c.CodeChallenge,
+		&c.CreatedAt, &c.ExpiresAt, &c.UsedAt, &c.FamilyID)
+	c.Scopes = strings.Fields(scopes)
+	return c, err
+}
+
+func insertOAuthCode(tx *sql.Tx, c OAuthCode) error {
+	_, err := tx.Exec(`INSERT INTO oauth_codes (`+oauthCodeColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.CodeHash, c.ClientID, c.UserID, c.RedirectURI, strings.Join(c.Scopes, " "), c.CodeChallenge,
+		c.CreatedAt, c.ExpiresAt, c.UsedAt, c.FamilyID)
+	return err
+}
+
+func (tx *sqliteTx) CreateOAuthClient(c OAuthClient) (OAuthClient, error) {
+	id, err := tx.nextID(oauthClientsTable)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO oauth_clients (id, client_id, owner_id, name, redirect_uris, secret_hash, created_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?)`,
+		id, c.ClientID, c.OwnerID, c.Name, strings.Join(c.RedirectURIs, " "), c.SecretHash, c.CreatedAt)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	c.ID = int(newID)
+	return c, nil
+}
+
+func (tx *sqliteTx) GetOAuthClient(clientID string) (OAuthClient, error) {
+	c, err := scanOAuthClient(tx.tx.QueryRow(`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE client_id = ?`, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OAuthClient{}, notFound("OAuth client %s not found", clientID)
+	}
+	return c, err
+}
+
+func (tx *sqliteTx) GetOAuthClientsByOwner(ownerID int) ([]OAuthClient, error) {
+	rows, err := tx.tx.Query(`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE owner_id = ? ORDER BY id ASC`, ownerID)
+	if err != nil {
+		return []OAuthClient{}, err
+	}
+	defer rows.Close()
+	clients := []OAuthClient{}
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return []OAuthClient{}, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+func (tx *sqliteTx) CreateOAuthCode(c OAuthCode) (OAuthCode, error) {
+	id, err := tx.nextID(oauthCodesTable)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO oauth_codes (id, code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, created_at, expires_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, c.CodeHash, c.ClientID, c.UserID, c.RedirectURI, strings.Join(c.Scopes, " "), c.CodeChallenge, c.CreatedAt, c.ExpiresAt)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return OAuthCode{}, err
+	}
+	c.ID = int(newID)
+	return c, nil
+}
+
+func (tx *sqliteTx) GetOAuthCode(codeHash string) (OAuthCode, error) {
+	c, err := scanOAuthCode(tx.tx.QueryRow(`SELECT `+oauthCodeColumns+` FROM oauth_codes WHERE code_hash = ?`, codeHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OAuthCode{}, notFound("Authorization code not found")
+	}
+	return c, err
+}
+
+func (tx *sqliteTx) UpdateOAuthCode(c OAuthCode) error {
+	res, err := tx.tx.Exec(`UPDATE oauth_codes SET code_hash = ?, client_id = ?, user_id = ?, redirect_uri = ?, scopes = ?,
+		code_challenge = ?, created_at = ?, expires_at = ?, used_at = ?, family_id = ? WHERE id = ?`,
+		c.CodeHash, c.ClientID, c.UserID, c.RedirectURI, strings.Join(c.Scopes, " "), c.CodeChallenge,
+		c.CreatedAt, c.ExpiresAt, c.UsedAt, c.FamilyID, c.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Authorization code %d not found", c.ID)
+	}
+	return nil
+}
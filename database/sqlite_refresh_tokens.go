@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const refreshTokenColumns = `id, user_id, token_hash, family_id, user_agent, ip, created_at, expires_at, replaced_by, revoked_at, client_id, scopes`
+
+func scanRefreshToken(row scanner) (RefreshToken, error) {
+	t := RefreshToken{}
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.TokenHash, &t.FamilyID, &t.UserAgent, &t.IP,
+		&t.CreatedAt, &t.ExpiresAt, &t.ReplacedBy, &t.RevokedAt, &t.ClientID, &scopes)
+	t.Scopes = strings.Fields(scopes)
+	return t, err
+}
+
+func insertRefreshToken(tx *sql.Tx, t RefreshToken) error {
+	_, err := tx.Exec(`INSERT INTO refresh_tokens (`+refreshTokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.TokenHash, t.FamilyID, t.UserAgent, t.IP, t.CreatedAt, t.ExpiresAt, t.ReplacedBy, t.RevokedAt,
+		t.ClientID, strings.Join(t.Scopes, " "))
+	return err
+}
+
+func (tx *sqliteTx) CreateRefreshToken(t RefreshToken) (RefreshToken, error) {
+	id, err := tx.nextID(refreshTokensTable)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	res, err := tx.tx.Exec(`INSERT INTO refresh_tokens (id, user_id, token_hash, family_id, user_agent, ip, created_at, expires_at, client_id, scopes)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, t.UserID, t.TokenHash, t.FamilyID, t.UserAgent, t.IP, t.CreatedAt, t.ExpiresAt, t.ClientID, strings.Join(t.Scopes, " "))
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	t.ID = int(newID)
+	if t.FamilyID == 0 {
+		t.FamilyID = t.ID
+		_, err = tx.tx.Exec(`UPDATE refresh_tokens SET family_id = ? WHERE id = ?`, t.FamilyID, t.ID)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+	}
+	return t, nil
+}
+
+func (tx *sqliteTx) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	t, err := scanRefreshToken(tx.tx.QueryRow(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = ?`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefreshToken{}, notFound("Refresh token not found")
+	}
+	return t, err
+}
+
+func (tx *sqliteTx) GetRefreshTokenByID(id int) (RefreshToken, error) {
+	t, err := scanRefreshToken(tx.tx.QueryRow(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefreshToken{}, notFound("Refresh token %d not found", id)
+	}
+	return t, err
+}
+
+func (tx *sqliteTx) GetRefreshTokensByUser(userID int) ([]RefreshToken, error) {
+	rows, err := tx.tx.Query(`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE user_id = ? ORDER BY id ASC`, userID)
+	if err != nil {
+		return []RefreshToken{}, err
+	}
+	defer rows.Close()
+	tokens := []RefreshToken{}
+	for rows.Next() {
+		t, err := scanRefreshToken(rows)
+		if err != nil {
+			return []RefreshToken{}, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (tx *sqliteTx) UpdateRefreshToken(t RefreshToken) error {
+	res, err := tx.tx.Exec(`UPDATE refresh_tokens SET user_id = ?, token_hash = ?, family_id = ?, user_agent = ?, ip = ?,
+		created_at = ?, expires_at = ?, replaced_by = ?, revoked_at = ?, client_id = ?, scopes = ? WHERE id = ?`,
+		t.UserID, t.TokenHash, t.FamilyID, t.UserAgent, t.IP, t.CreatedAt, t.ExpiresAt, t.ReplacedBy, t.RevokedAt,
+		t.ClientID, strings.Join(t.Scopes, " "), t.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound("Refresh token %d not found", t.ID)
+	}
+	return nil
+}
+
+func (tx *sqliteTx) RevokeRefreshTokenFamily(familyID int) error {
+	_, err := tx.tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at = 0`,
+		time.Now().UTC().Unix(), familyID)
+	return err
+}
@@ -0,0 +1,121 @@
+package database
+
+// Store is the storage backend the API depends on. Handlers only talk to a
+// Store, so the JSON file, SQLite and in-memory backends can be swapped
+// without touching them.
+//
+// All reads and writes go through a transaction. View transactions see a
+// consistent state and may not write. Update transactions are isolated from
+// each other and are either committed as a whole when fn returns nil or
+// rolled back when it returns an error.
+type Store interface {
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx is the set of operations available inside a transaction. A Tx must not
+// be used after its fn has returned.
+type Tx interface {
+	UserStore
+	ChirpStore
+	RefreshTokenStore
+	APIKeyStore
+	OAuthStore
+	IdentityStore
+	LikeStore
+}
+
+type UserStore interface {
+	CreateUser(email string, password string) (User, error)
+	// GetUser finds the user by email, ignoring case.
+	GetUser(email string) (User, error)
+	GetUserByID(id int) (User, error)
+	UpdateUser(id int, u User) (User, error)
+	// GetUsersToDelete returns the users whose DeleteAfter is set and not
+	// later than now.
+	GetUsersToDelete(now int64) ([]User, error)
+	// DeleteUser deletes the user along with their refresh tokens, API
+	// keys, likes, identities, OAuth clients and authorization codes.
+	// Their chirps are left for the caller to deal with.
+	DeleteUser(id int) error
+}
+
+type ChirpStore interface {
+	CreateChirp(c Chirp) (Chirp, error)
+	GetChirps(sortOrder string) ([]Chirp, error)
+	GetChirpsByAuthor(authorID int) ([]Chirp, error)
+	GetChirp(chirpID int) (Chirp, error)
+	// GetChirpReplies returns the direct replies to chirpID, oldest first.
+	GetChirpReplies(chirpID int) ([]Chirp, error)
+	// GetRechirp returns authorID's rechirp of chirpID.
+	GetRechirp(authorID int, chirpID int) (Chirp, error)
+	// CountRechirps returns how many rechirps each of chirpIDs has.
+	CountRechirps(chirpIDs []int) (map[int]int, error)
+	UpdateChirp(c Chirp) error
+	// DeleteChirp deletes the chirp with its revisions, likes and rechirps.
+	// Replies and quotes are kept.
+	DeleteChirp(chirpID int) error
+	CreateChirpRevision(r ChirpRevision) (ChirpRevision, error)
+	// GetChirpRevisions returns the earlier versions of chirpID, oldest
+	// first.
+	GetChirpRevisions(chirpID int) ([]ChirpRevision, error)
+}
+
+type RefreshTokenStore interface {
+	// CreateRefreshToken stores t under a new ID. A zero FamilyID starts a
+	// new family named after that ID.
+	CreateRefreshToken(t RefreshToken) (RefreshToken, error)
+	GetRefreshToken(tokenHash string) (RefreshToken, error)
+	GetRefreshTokenByID(id int) (RefreshToken, error)
+	// GetRefreshTokensByUser returns every token ever issued to userID,
+	// oldest first.
+	GetRefreshTokensByUser(userID int) ([]RefreshToken, error)
+	UpdateRefreshToken(t RefreshToken) error
+	// RevokeRefreshTokenFamily revokes every token descended from the
+	// login that issued familyID.
+	RevokeRefreshTokenFamily(familyID int) error
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(k APIKey) (APIKey, error)
+	GetAPIKey(keyHash string) (APIKey, error)
+	GetAPIKeyByID(id int) (APIKey, error)
+	// GetAPIKeysByUser returns every key userID ever made, oldest first.
+	GetAPIKeysByUser(userID int) ([]APIKey, error)
+	UpdateAPIKey(k APIKey) error
+}
+
+type OAuthStore interface {
+	CreateOAuthClient(c OAuthClient) (OAuthClient, error)
+	GetOAuthClient(clientID string) (OAuthClient, error)
+	GetOAuthClientsByOwner(ownerID int) ([]OAuthClient, error)
+	CreateOAuthCode(c OAuthCode) (OAuthCode, error)
+	GetOAuthCode(codeHash string) (OAuthCode, error)
+	UpdateOAuthCode(c OAuthCode) error
+}
+
+type IdentityStore interface {
+	CreateIdentity(i Identity) (Identity, error)
+	GetIdentity(issuer string, subject string) (Identity, error)
+	GetIdentityByID(id int) (Identity, error)
+	GetIdentitiesByUser(userID int) ([]Identity, error)
+	UpdateIdentity(i Identity) error
+	DeleteIdentity(id int) error
+}
+
+type LikeStore interface {
+	// CreateLike fails if the user already likes the chirp.
+	CreateLike(l Like) (Like, error)
+	GetLike(userID int, chirpID int) (Like, error)
+	// GetLikesByUser returns every like of userID, oldest first.
+	GetLikesByUser(userID int) ([]Like, error)
+	// CountLikes returns how many likes each of chirpIDs has.
+	CountLikes(chirpIDs []int) (map[int]int, error)
+	// GetLikedChirps returns which of chirpIDs userID likes.
+	GetLikedChirps(userID int, chirpIDs []int) (map[int]bool, error)
+	DeleteLike(id int) error
+}
+
+var _ Store = (*DB)(nil)
+var _ Store = (*MemoryStore)(nil)
+var _ Tx = (*dbTx)(nil)
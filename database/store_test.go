@@ -0,0 +1,420 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// stores are the backends every Store test runs against.
+var stores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store {
+		return NewMemoryStore()
+	}},
+	{"json", func(t *testing.T) Store {
+		db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}},
+	{"sqlite", func(t *testing.T) Store {
+		db, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chirpy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}},
+}
+
+// update runs fn in an Update transaction and fails the test if it fails.
+func update(t *testing.T, db Store, fn func(tx Tx) error) {
+	t.Helper()
+	err := db.Update(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// view runs fn in a View transaction and fails the test if it fails.
+func view(t *testing.T, db Store, fn func(tx Tx) error) {
+	t.Helper()
+	err := db.View(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			db := s.open(t)
+			var a, b User
+			update(t, db, func(tx Tx) error {
+				var err error
+				a, err = tx.CreateUser("a@example.com", "hash-a")
+				if err != nil {
+					return err
+				}
+				b, err = tx.CreateUser("b@example.com", "hash-b")
+				return err
+			})
+			if a.ID == b.ID {
+				t.Fatalf("both users have ID %d", a.ID)
+			}
+			for _, email := range []string{"a@example.com", "A@Example.com"} {
+				err := db.Update(func(tx Tx) error {
+					_, err := tx.CreateUser(email, "hash-c")
+					return err
+				})
+				if err == nil {
+					t.Errorf("CreateUser accepted %s, which is taken", email)
+				}
+			}
+
+			view(t, db, func(tx Tx) error {
+				got, err := tx.GetUser("b@example.com")
+				if err != nil {
+					return err
+				}
+				if got.ID != b.ID || got.Password != "hash-b" {
+					t.Errorf("GetUser returned %+v, want %+v", got, b)
+				}
+				got, err = tx.GetUser("B@EXAMPLE.com")
+				if err != nil {
+					return err
+				}
+				if got.ID != b.ID {
+					t.Errorf("GetUser ignoring case returned user %d, want %d", got.ID, b.ID)
+				}
+				_, err = tx.GetUser("c@example.com")
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetUser of an unknown email returned %v, want ErrNotFound", err)
+				}
+				return nil
+			})
+
+			a.IsChirpyRed = true
+			update(t, db, func(tx Tx) error {
+				_, err := tx.UpdateUser(a.ID, a)
+				return err
+			})
+			view(t, db, func(tx Tx) error {
+				got, err := tx.GetUserByID(a.ID)
+				if err != nil {
+					return err
+				}
+				if !got.IsChirpyRed {
+					t.Error("UpdateUser did not store the change")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestStoreChirps(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			db := s.open(t)
+			update(t, db, func(tx Tx) error {
+				for _, c := range []struct {
+					body     string
+					authorID int
+				}{
+					{"first", 1},
+					{"second", 2},
+					{"third", 1},
+				} {
+					_, err := tx.CreateChirp(Chirp{AuthorID: c.authorID, Body: c.body})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			tests := []struct {
+				name string
+				get  func(tx Tx) ([]Chirp, error)
+				want []string
+			}{
+				{"ascending", func(tx Tx) ([]Chirp, error) { return tx.GetChirps("asc") }, []string{"first", "second", "third"}},
+				{"descending", func(tx Tx) ([]Chirp, error) { return tx.GetChirps("desc") }, []string{"third", "second", "first"}},
+				{"by author", func(tx Tx) ([]Chirp, error) { return tx.GetChirpsByAuthor(1) }, []string{"first", "third"}},
+			}
+			view(t, db, func(tx Tx) error {
+				for _, tt := range tests {
+					chirps, err := tt.get(tx)
+					if err != nil {
+						return err
+					}
+					if got := chirpBodies(chirps); !slices.Equal(got, tt.want) {
+						t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+					}
+				}
+				chirp, err := tx.GetChirp(2)
+				if err != nil {
+					return err
+				}
+				if chirp.Body != "second" || chirp.AuthorID != 2 {
+					t.Errorf("GetChirp returned %+v", chirp)
+				}
+				return nil
+			})
+
+			update(t, db, func(tx Tx) error {
+				return tx.DeleteChirp(3)
+			})
+			err := db.Update(func(tx Tx) error {
+				return tx.DeleteChirp(3)
+			})
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("deleting a chirp twice returned %v, want ErrNotFound", err)
+			}
+			view(t, db, func(tx Tx) error {
+				chirps, err := tx.GetChirps("asc")
+				if err != nil {
+					return err
+				}
+				if got, want := chirpBodies(chirps), []string{"first", "second"}; !slices.Equal(got, want) {
+					t.Errorf("after delete: got %v, want %v", got, want)
+				}
+				_, err = tx.GetChirp(3)
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetChirp of a deleted chirp returned %v, want ErrNotFound", err)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func chirpBodies(chirps []Chirp) []string {
+	bodies := []string{}
+	for _, c := range chirps {
+		bodies = append(bodies, c.Body)
+	}
+	return bodies
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			db := s.open(t)
+			var token RefreshToken
+			update(t, db, func(tx Tx) error {
+				user, err := tx.CreateUser("a@example.com", "hash")
+				if err != nil {
+					return err
+				}
+				token, err = tx.CreateRefreshToken(RefreshToken{
+					UserID:    user.ID,
+					TokenHash: "hash",
+					ExpiresAt: 1,
+					ClientID:  "app",
+					Scopes:    []string{"chirps:write", "chirps:read"},
+				})
+				return err
+			})
+			if token.FamilyID != token.ID {
+				t.Errorf("new token has family %d, want its own ID %d", token.FamilyID, token.ID)
+			}
+			view(t, db, func(tx Tx) error {
+				got, err := tx.GetRefreshToken("hash")
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(got, token) {
+					t.Errorf("GetRefreshToken returned %+v, want %+v", got, token)
+				}
+				_, err = tx.GetRefreshToken("other")
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetRefreshToken of an unknown hash returned %v, want ErrNotFound", err)
+				}
+				return nil
+			})
+			token.ReplacedBy = 2
+			update(t, db, func(tx Tx) error {
+				return tx.UpdateRefreshToken(token)
+			})
+			view(t, db, func(tx Tx) error {
+				got, err := tx.GetRefreshToken("hash")
+				if err != nil {
+					return err
+				}
+				if got.ReplacedBy != 2 {
+					t.Error("UpdateRefreshToken did not store the change")
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestUpdateRollsBackOnError(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name string
+		fn   func(tx Tx) error
+	}{
+		{"create", func(tx Tx) error {
+			_, err := tx.CreateUser("b@example.com", "hash")
+			if err != nil {
+				return err
+			}
+			return errFail
+		}},
+		{"update", func(tx Tx) error {
+			u, err := tx.GetUser("a@example.com")
+			if err != nil {
+				return err
+			}
+			u.Email = "c@example.com"
+			_, err = tx.UpdateUser(u.ID, u)
+			if err != nil {
+				return err
+			}
+			return errFail
+		}},
+		{"delete", func(tx Tx) error {
+			err := tx.DeleteChirp(1)
+			if err != nil {
+				return err
+			}
+			return errFail
+		}},
+		{"panic", func(tx Tx) error {
+			_, err := tx.CreateUser("b@example.com", "hash")
+			if err != nil {
+				return err
+			}
+			panic(errFail)
+		}},
+	}
+	for _, s := range stores {
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				db := s.open(t)
+				update(t, db, func(tx Tx) error {
+					u, err := tx.CreateUser("a@example.com", "hash")
+					if err != nil {
+						return err
+					}
+					_, err = tx.CreateChirp(Chirp{AuthorID: u.ID, Body: "hello"})
+					return err
+				})
+
+				err := func() (err error) {
+					defer func() {
+						if p := recover(); p != nil {
+							err = p.(error)
+						}
+					}()
+					return db.Update(tt.fn)
+				}()
+				if !errors.Is(err, errFail) {
+					t.Fatalf("Update returned %v, want %v", err, errFail)
+				}
+
+				view(t, db, func(tx Tx) error {
+					u, err := tx.GetUser("a@example.com")
+					if err != nil {
+						return err
+					}
+					for _, email := range []string{"b@example.com", "c@example.com"} {
+						if _, err := tx.GetUser(email); !errors.Is(err, ErrNotFound) {
+							t.Errorf("GetUser(%q) returned %v, want ErrNotFound", email, err)
+						}
+					}
+					chirps, err := tx.GetChirpsByAuthor(u.ID)
+					if err != nil {
+						return err
+					}
+					if len(chirps) != 1 {
+						t.Errorf("user a has %d chirps, want 1", len(chirps))
+					}
+					return nil
+				})
+
+				// The store still takes writes.
+				update(t, db, func(tx Tx) error {
+					_, err := tx.CreateUser("d@example.com", "hash")
+					return err
+				})
+			})
+		}
+	}
+}
+
+func TestViewIsReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(tx Tx) error
+	}{
+		{"create user", func(tx Tx) error {
+			_, err := tx.CreateUser("b@example.com", "hash")
+			return err
+		}},
+		{"create chirp", func(tx Tx) error {
+			_, err := tx.CreateChirp(Chirp{AuthorID: 1, Body: "hello"})
+			return err
+		}},
+		{"delete chirp", func(tx Tx) error {
+			return tx.DeleteChirp(1)
+		}},
+		{"create refresh token", func(tx Tx) error {
+			_, err := tx.CreateRefreshToken(RefreshToken{UserID: 1, TokenHash: "token"})
+			return err
+		}},
+	}
+	for _, s := range stores {
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				db := s.open(t)
+				update(t, db, func(tx Tx) error {
+					u, err := tx.CreateUser("a@example.com", "hash")
+					if err != nil {
+						return err
+					}
+					_, err = tx.CreateChirp(Chirp{AuthorID: u.ID, Body: "hello"})
+					return err
+				})
+
+				err := db.View(tt.fn)
+				if err == nil {
+					t.Fatal("View allowed a write")
+				}
+
+				view(t, db, func(tx Tx) error {
+					if _, err := tx.GetUser("b@example.com"); !errors.Is(err, ErrNotFound) {
+						t.Errorf("user written in View exists: %v", err)
+					}
+					chirps, err := tx.GetChirps("asc")
+					if err != nil {
+						return err
+					}
+					if len(chirps) != 1 {
+						t.Errorf("there are %d chirps, want 1", len(chirps))
+					}
+					if _, err := tx.GetRefreshToken("token"); err == nil {
+						t.Error("refresh token written in View verifies")
+					}
+					return nil
+				})
+				// Leaving View makes the store writable again.
+				update(t, db, func(tx Tx) error {
+					_, err := tx.CreateUser("c@example.com", "hash")
+					return err
+				})
+			})
+		}
+	}
+}
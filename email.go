@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/bigbabyjack/chirpy/mail"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Email tokens are JWTs with their own audiences, so neither kind can be
+// used as the other or as an access token. They are made single-use by
+// binding them to the state they change: a verification token to the
+// address it was sent to, a reset token to the password hash it replaces.
+const (
+	verifyEmailAudience   = "chirpy-verify-email"
+	resetPasswordAudience = "chirpy-reset-password"
+	verifyEmailTTL        = 24 * time.Hour
+	resetPasswordTTL      = time.Hour
+)
+
+var errInvalidEmailToken = errors.New("Invalid or expired token.")
+
+type emailTokenClaims struct {
+	jwt.RegisteredClaims
+	Email               string `json:"email,omitempty"`
+	PasswordFingerprint string `json:"pwd,omitempty"`
+}
+
+// mailerFromEnv picks the mailer from MAIL_DRIVER: "smtp" sends through
+// SMTP_ADDR (with SMTP_USERNAME and SMTP_PASSWORD), "file" writes to
+// MAIL_DIR, and "log" logs every message. Messages carry verification and
+// reset tokens, so they are only logged when asked for by name, or in
+// debug mode when MAIL_DRIVER is unset; otherwise it has to be set.
+func mailerFromEnv(debug bool) (mail.Mailer, error) {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "":
+		if !debug {
+			return nil, errors.New("MAIL_DRIVER is required: set it to smtp, file or log")
+		}
+		log.Printf("MAIL_DRIVER is not set; logging outgoing mail in debug mode")
+		return mail.NewFileMailer("")
+	case "log":
+		log.Printf("MAIL_DRIVER=log: outgoing mail, including its tokens, is written to the log")
+		return mail.NewFileMailer("")
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return mail.NewFileMailer(dir)
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, errors.New("SMTP_ADDR is required with MAIL_DRIVER=smtp")
+		}
+		return mail.NewSMTPMailer(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+	return nil, fmt.Errorf("Unknown MAIL_DRIVER: %s", os.Getenv("MAIL_DRIVER"))
+}
+
+// normalizeEmail trims and lower-cases an address, so it is stored and
+// looked up the same way however it was typed or a provider spelled it.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validEmail accepts a bare address such as "a@example.com", without a
+// display name or angle brackets.
+func validEmail(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// passwordFingerprint changes whenever the password does.
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (cfg *apiConfig) issueEmailToken(audience string, claims emailTokenClaims, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims.Issuer = jwtIssuer
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return cfg.keys.Sign(claims)
+}
+
+func (cfg *apiConfig) parseEmailToken(audience string, tokenString string) (int, *emailTokenClaims, error) {
+	token, err := cfg.keys.Parse(tokenString, &emailTokenClaims{})
+	if err != nil {
+		return 0, nil, errInvalidEmailToken
+	}
+	claims := token.Claims.(*emailTokenClaims)
+	if claims.ExpiresAt == nil || !claims.VerifyIssuer(jwtIssuer, true) || !claims.VerifyAudience(audience, true) {
+		return 0, nil, errInvalidEmailToken
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, nil, errInvalidEmailToken
+	}
+	return userID, claims, nil
+}
+
+// appLink returns a link to path in the web app with token in the query.
+func (cfg *apiConfig) appLink(path string, token string) string {
+	return cfg.appURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// sendMail delivers msg in the background; failures are only logged.
+func (cfg *apiConfig) sendMail(msg mail.Message) {
+	msg.From = cfg.mailFrom
+	go func() {
+		err := cfg.mailer.Send(msg)
+		if err != nil {
+			log.Printf("Unable to send mail to %s: %s", msg.To, err)
+		}
+	}()
+}
+
+// sendVerificationEmail asks user to confirm they own email.
+func (cfg *apiConfig) sendVerificationEmail(user database.User, email string) error {
+	token, err := cfg.issueEmailToken(verifyEmailAudience, emailTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.Itoa(user.ID)},
+		Email:            email,
+	}, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Confirm this is your email address by opening\n\n%s\n\n"+
+			"or by sending this token to POST /api/email/verify:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't sign up for Chirpy, ignore this email.\n",
+			cfg.appLink("/verify-email", token), token),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(user database.User) error {
+	token, err := cfg.issueEmailToken(resetPasswordAudience, emailTokenClaims{
+		RegisteredClaims:    jwt.RegisteredClaims{Subject: strconv.Itoa(user.ID)},
+		Email:               user.Email,
+		PasswordFingerprint: passwordFingerprint(user.Password),
+	}, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Choose a new password by opening\n\n%s\n\n"+
+			"or by sending this token to POST /api/password/reset:\n\n%s\n\n"+
+			"The link expires in an hour and works once. If you didn't ask to reset your password, ignore this email.\n",
+			cfg.appLink("/reset-password", token), token),
+	})
+	return nil
+}
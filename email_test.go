@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMailerFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  string
+		smtp    string
+		debug   bool
+		wantErr bool
+	}{
+		{"unset", "", "", false, true},
+		{"unset in debug mode", "", "", true, false},
+		{"log", "log", "", false, false},
+		{"file", "file", "", false, false},
+		{"smtp", "smtp", "localhost:25", false, false},
+		{"smtp without address", "smtp", "", false, true},
+		{"unknown", "carrier-pigeon", "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAIL_DRIVER", tt.driver)
+			t.Setenv("MAIL_DIR", t.TempDir())
+			t.Setenv("SMTP_ADDR", tt.smtp)
+			_, err := mailerFromEnv(tt.debug)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("mailerFromEnv returned %v, want error = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/bigbabyjack/chirpy/mail"
+)
+
+var (
+	errDeletionPending    = errors.New("Your account is already scheduled for deletion.")
+	errNoDeletionToCancel = errors.New("Your account is not scheduled for deletion.")
+)
+
+const exportReadme = `This archive holds everything Chirpy keeps about your account:
+
+profile.json        your account
+chirps.json         the chirps you posted
+likes.json          the chirps you liked
+sessions.json       the devices and apps signed in as you
+api_keys.json       your API keys, without the keys themselves
+identities.json     the external accounts you sign in with
+oauth_clients.json  the apps you registered
+
+Times are in UTC.
+`
+
+// handlerExportAccount answers with a zip archive of the caller's data, as
+// JSON files. Secrets such as password hashes are left out.
+func (cfg *apiConfig) handlerExportAccount(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var (
+		user       database.User
+		chirps     []ChirpResponse
+		likes      []database.Like
+		sessions   []Session
+		keys       []database.APIKey
+		identities []database.Identity
+		clients    []database.OAuthClient
+	)
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		user, err = tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		authored, err := tx.GetChirpsByAuthor(user.ID)
+		if err != nil {
+			return err
+		}
+		chirps, err = chirpResponses(tx, user.ID, authored...)
+		if err != nil {
+			return err
+		}
+		likes, err = tx.GetLikesByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		sessions, err = activeSessions(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		keys, err = tx.GetAPIKeysByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		identities, err = tx.GetIdentitiesByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		clients, err = tx.GetOAuthClientsByOwner(user.ID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve account data.")
+		return
+	}
+
+	likeResponses := make([]LikeResponse, 0, len(likes))
+	for _, l := range likes {
+		likeResponses = append(likeResponses, newLikeResponse(l))
+	}
+	keyResponses := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		keyResponses = append(keyResponses, newAPIKeyResponse(k))
+	}
+	identityResponses := make([]IdentityResponse, 0, len(identities))
+	for _, i := range identities {
+		identityResponses = append(identityResponses, cfg.newIdentityResponse(i))
+	}
+	clientResponses := make([]OAuthClientResponse, 0, len(clients))
+	for _, c := range clients {
+		clientResponses = append(clientResponses, newOAuthClientResponse(c))
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	for _, f := range []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", newUserResponse(user)},
+		{"chirps.json", chirps},
+		{"likes.json", likeResponses},
+		{"sessions.json", sessions},
+		{"api_keys.json", keyResponses},
+		{"identities.json", identityResponses},
+		{"oauth_clients.json", clientResponses},
+		{"README.txt", exportReadme},
+	} {
+		err = writeArchiveFile(archive, f.name, f.v)
+		if err != nil {
+			log.Printf("Unable to export account of user %d: %s", user.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Unable to export account data.")
+			return
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		log.Printf("Unable to export account of user %d: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to export account data.")
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%d-%s.zip", user.ID, time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// writeArchiveFile adds v to archive as name: strings as they are,
+// anything else as indented JSON.
+func writeArchiveFile(archive *zip.Writer, name string, v any) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if s, ok := v.(string); ok {
+		_, err = io.WriteString(f, s)
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// handlerDeleteAccount schedules the caller's account for deletion after
+// the grace period, or deletes it right away if there is none. Either way
+// every session and API key is revoked. Users with a password have to
+// confirm it.
+func (cfg *apiConfig) handlerDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding parameters: %s", err))
+		return
+	}
+
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to look up user.")
+		return
+	}
+	if user.DeleteAfter != 0 {
+		respondWithError(w, http.StatusConflict, errDeletionPending.Error())
+		return
+	}
+
+	// Users who only sign in with an external provider have no password
+	// to confirm; their access token is all there is.
+	if user.Password != "" {
+		wait, done := cfg.logins.begin(user.Email, clientIP(r))
+		if wait > 0 {
+			respondLoginThrottled(w, wait)
+			return
+		}
+		err = cfg.passwords.Verify(user.Password, params.CurrentPassword)
+		done(!errors.Is(err, auth.ErrPasswordMismatch))
+		if errors.Is(err, auth.ErrPasswordMismatch) {
+			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect.")
+			return
+		}
+		if err != nil {
+			log.Printf("Unable to verify password of user %d: %s", user.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Unable to verify password.")
+			return
+		}
+		cfg.logins.succeed(user.Email)
+	}
+
+	deleteAfter := time.Now().UTC().Add(cfg.deletionGrace)
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if user.DeleteAfter != 0 {
+			return errDeletionPending
+		}
+		if cfg.deletionGrace == 0 {
+			return cfg.deleteAccount(tx, user.ID)
+		}
+		user.DeleteAfter = deleteAfter.Unix()
+		_, err = tx.UpdateUser(user.ID, user)
+		if err != nil {
+			return err
+		}
+		return revokeAllCredentials(tx, user.ID)
+	})
+	if errors.Is(err, errDeletionPending) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to delete account of user %d: %s", principal.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to delete account.")
+		return
+	}
+
+	if cfg.deletionGrace == 0 {
+		cfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy account was deleted",
+			Body:    "Your Chirpy account and everything in it was deleted as you asked.\n",
+		})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf("Your Chirpy account will be deleted for good on %s. "+
+			"You were signed out everywhere and your API keys were revoked.\n\n"+
+			"Changed your mind? Sign in and send POST /api/account/restore before then.\n\n"+
+			"If this wasn't you, sign in, restore your account and reset your password right away.\n",
+			deleteAfter.Format(time.RFC1123)),
+	})
+	respondWithJSON(w, http.StatusAccepted, newUserResponse(user))
+}
+
+// handlerRestoreAccount takes back the caller's request to delete their
+// account. Revoked sessions and API keys stay revoked.
+func (cfg *apiConfig) handlerRestoreAccount(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var user database.User
+	err := cfg.db.Update(func(tx database.Tx) error {
+		var err error
+		user, err = tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if user.DeleteAfter == 0 {
+			return errNoDeletionToCancel
+		}
+		user.DeleteAfter = 0
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if errors.Is(err, errNoDeletionToCancel) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to restore account.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
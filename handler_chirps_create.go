@@ -2,68 +2,102 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/bigbabyjack/chirpy/database"
 )
 
-func (cfg *apiConfig) handlerCreateChirps(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := getBearerTokenFromHeader(r)
-	if err != nil {
-		respondWithError(w, 401, err.Error())
-	}
-	fmt.Println(tokenString)
-	fmt.Println(cfg.jwtSecret)
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("Cannot parse JWT token: %s", err.Error()))
-		return
-	}
+var errNoSuchParent = errors.New("The chirp you are replying to does not exist.")
 
-	claims := token.Claims.(*jwt.RegisteredClaims)
-	id := claims.Subject
-
-	authorID, err := strconv.Atoi(id)
-	if err != nil {
-		respondWithError(w, 500, err.Error())
-		return
-	}
+func (cfg *apiConfig) handlerCreateChirps(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	authorID := principal.UserID
 
 	// get the body of the request
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string `json:"body"`
+		InReplyTo int    `json:"in_reply_to"`
+		QuoteOf   int    `json:"quote_of"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	// decode body
 	if err != nil {
 		log.Printf("Error decoding parameters %s\n", err)
 		respondWithError(w, 500, "Error decoding parameters.")
 		return
 	}
-	// check length of chirp
-	if len(params.Body) > 140 {
+	// check length of chirp and for profanity
+	cleanedBody, err := cleanChirpBody(params.Body)
+	if err != nil {
 		log.Printf("Chirp is too long")
-		respondWithError(w, 400, "Chirp is too long")
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if params.QuoteOf != 0 && cleanedBody == "" {
+		respondWithError(w, http.StatusBadRequest, errEmptyQuote.Error())
 		return
 	}
-	// check for profanity
-	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-	cleanedBody := getCleanedBody(profaneWords, params.Body)
 
-	c, err := cfg.db.CreateChirp(cleanedBody, authorID)
+	var resp []ChirpResponse
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp := database.Chirp{
+			Body:      cleanedBody,
+			AuthorID:  authorID,
+			CreatedAt: time.Now().UTC().Unix(),
+			Revisions: 1,
+		}
+		if params.InReplyTo != 0 {
+			parent, err := tx.GetChirp(params.InReplyTo)
+			if errors.Is(err, database.ErrNotFound) {
+				return errNoSuchParent
+			}
+			if err != nil {
+				return err
+			}
+			parent, err = originalOf(tx, parent)
+			if err != nil {
+				return err
+			}
+			chirp.InReplyTo = parent.ID
+			chirp.ConversationID = conversationOf(parent)
+		}
+		if params.QuoteOf != 0 {
+			quoted, err := tx.GetChirp(params.QuoteOf)
+			if errors.Is(err, database.ErrNotFound) {
+				return errNoSuchOriginal
+			}
+			if err != nil {
+				return err
+			}
+			quoted, err = originalOf(tx, quoted)
+			if err != nil {
+				return err
+			}
+			chirp.QuoteOf = quoted.ID
+		}
+		c, err := tx.CreateChirp(chirp)
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, authorID, c)
+		return err
+	})
+	if errors.Is(err, errNoSuchParent) || errors.Is(err, errNoSuchOriginal) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		log.Printf("Error saving chirp.")
 		errMsg := fmt.Sprintf("Error saving chirp: %s", err)
 		respondWithError(w, 500, errMsg)
 		return
 	}
-	respondWithJSON(w, 201, c)
+	respondWithJSON(w, 201, resp[0])
 	return
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestCreateChirp(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{"plain", `{"body":"hello"}`, http.StatusCreated, "hello"},
+		{"profane", `{"body":"what a Kerfuffle"}`, http.StatusCreated, "what a ****"},
+		{"too long", `{"body":"` + strings.Repeat("a", 141) + `"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			token, err := cfg.issueAccessToken(database.User{ID: 7}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			cfg.requireScope(ScopeChirpsWrite, cfg.handlerCreateChirps)(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+
+			var chirps []database.Chirp
+			err = cfg.db.View(func(tx database.Tx) error {
+				chirps, err = tx.GetChirps("asc")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantBody == "" {
+				if len(chirps) != 0 {
+					t.Errorf("stored %v", chirps)
+				}
+				return
+			}
+			var resp ChirpResponse
+			decodeResponse(t, w, &resp)
+			if len(chirps) != 1 || chirps[0].ID != resp.ID || chirps[0].Body != resp.Body ||
+				resp.Body != tt.wantBody || resp.AuthorID != 7 || resp.CreatedAt == nil {
+				t.Errorf("answered %+v and stored %+v, want %q by 7", resp, chirps, tt.wantBody)
+			}
+		})
+	}
+}
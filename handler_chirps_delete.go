@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// handlerDeleteChirp deletes a chirp along with its rechirps. Replies and
+// quotes of it stay; quotes show its ID in quote_of but no longer show it.
+// Deleting a rechirp undoes it.
+func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	authorID := principal.UserID
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", chirpID))
+		return
+	}
+
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		if authorID != chirp.AuthorID && !principal.HasScope(ScopeChirpsModerate) {
+			return errForbidden
+		}
+		return tx.DeleteChirp(chirp.ID)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, 404, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		respondWithError(w, 403, "Unauthorized.")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
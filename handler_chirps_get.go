@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+
+	"github.com/bigbabyjack/chirpy/database"
 )
 
 func parseAuthorID(r *http.Request) (int, error) {
@@ -26,29 +29,46 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if authorID != 0 {
-		chirps, err := cfg.db.GetChirpsByAuthor(authorID)
+		var resp []ChirpResponse
+		err := cfg.db.View(func(tx database.Tx) error {
+			chirps, err := tx.GetChirpsByAuthor(authorID)
+			if err != nil {
+				return err
+			}
+			resp, err = chirpResponses(tx, viewerOf(r), chirps...)
+			return err
+		})
 		if err != nil {
 			respondWithError(w, 500, "Unable to retrieve chirps.")
 			return
 		}
-		respondWithJSON(w, 200, chirps)
+		respondWithJSON(w, 200, resp)
 		return
 	}
 
-	order := r.URL.Query().Get("sort")
-	sortOrder := "asc"
-	if order == "desc" {
-		sortOrder = "desc"
-	} else if order != "asc" {
+	sortOrder := r.URL.Query().Get("sort")
+	if sortOrder == "" {
+		sortOrder = "asc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
 		respondWithError(w, http.StatusBadRequest, "Invalid parameter for sort")
+		return
 	}
 
-	chirps, err := cfg.db.GetChirps(sortOrder)
+	var resp []ChirpResponse
+	err = cfg.db.View(func(tx database.Tx) error {
+		chirps, err := tx.GetChirps(sortOrder)
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, viewerOf(r), chirps...)
+		return err
+	})
 	if err != nil {
 		respondWithError(w, 500, "Unable to retrieve chirps.")
 		return
 	}
-	respondWithJSON(w, 200, chirps)
+	respondWithJSON(w, 200, resp)
 	return
 
 }
@@ -59,12 +79,24 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", chirpID))
 		return
 	}
-	chirp, err := cfg.db.GetChirp(chirpID)
-	if err != nil {
+	var resp []ChirpResponse
+	err = cfg.db.View(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, viewerOf(r), chirp)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
 		respondWithError(w, 404, fmt.Sprintf("Chirp with ID %v not found", chirpID))
 		return
 	}
-	respondWithJSON(w, 200, chirp)
+	if err != nil {
+		respondWithError(w, 500, "Unable to retrieve chirp.")
+		return
+	}
+	respondWithJSON(w, 200, resp[0])
 	return
 
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// A thread shows replies depth levels deep and at most limit replies per
+// chirp, breadth first, until maxThreadChirps replies are shown in all.
+// Ancestors are shown up to maxThreadAncestors back.
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+	defaultThreadLimit = 20
+	maxThreadLimit     = 100
+	maxThreadChirps    = 500
+	maxThreadAncestors = 50
+)
+
+// ThreadNode is a chirp with the replies shown under it. ReplyCount counts
+// all its direct replies, shown or not. When more of them follow the ones
+// shown, NextAfter is set; asking for the thread of the chirp with it as
+// after shows the next ones.
+type ThreadNode struct {
+	ChirpResponse
+	ReplyCount int           `json:"reply_count"`
+	NextAfter  *int          `json:"next_after,omitempty"`
+	Replies    []*ThreadNode `json:"replies"`
+}
+
+// ThreadResponse is a chirp in its conversation. Ancestors run from the
+// oldest shown to the chirp replied to. If the first one is itself a reply,
+// the chirps above it were deleted or are too far up to show.
+type ThreadResponse struct {
+	Ancestors []ChirpResponse `json:"ancestors"`
+	Chirp     *ThreadNode     `json:"chirp"`
+}
+
+func newThreadNode(c database.Chirp) *ThreadNode {
+	return &ThreadNode{ChirpResponse: newChirpResponse(c), Replies: []*ThreadNode{}}
+}
+
+// queryInt reads the query parameter name as a number from lo to hi,
+// returning fallback if it is absent.
+func queryInt(r *http.Request, name string, fallback int, lo int, hi int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be a number from %d to %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// handlerGetChirpThread shows a chirp with the chirps it replies to and
+// the replies under it, as a tree. Replies are shown oldest first; depth,
+// limit and after page through them.
+func (cfg *apiConfig) handlerGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+	depth, err := queryInt(r, "depth", defaultThreadDepth, 0, maxThreadDepth)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultThreadLimit, 1, maxThreadLimit)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	after, err := queryInt(r, "after", 0, 0, math.MaxInt)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "after must be the ID of a reply")
+		return
+	}
+
+	var thread ThreadResponse
+	err = cfg.db.View(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		thread.Ancestors, err = threadAncestors(tx, chirp)
+		if err != nil {
+			return err
+		}
+		thread.Chirp, err = threadReplies(tx, chirp, depth, limit, after)
+		if err != nil {
+			return err
+		}
+		chirps := []*ChirpResponse{}
+		for i := range thread.Ancestors {
+			chirps = append(chirps, &thread.Ancestors[i])
+		}
+		for queue := []*ThreadNode{thread.Chirp}; len(queue) > 0; queue = queue[1:] {
+			chirps = append(chirps, &queue[0].ChirpResponse)
+			queue = append(queue, queue[0].Replies...)
+		}
+		return completeChirpResponses(tx, viewerOf(r), chirps...)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve thread.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, thread)
+}
+
+// threadAncestors walks up from c to the start of its conversation, or to
+// the first chirp that was deleted, and returns the chirps oldest first.
+func threadAncestors(tx database.Tx, c database.Chirp) ([]ChirpResponse, error) {
+	ancestors := []ChirpResponse{}
+	for parentID := c.InReplyTo; parentID != 0 && len(ancestors) < maxThreadAncestors; {
+		parent, err := tx.GetChirp(parentID)
+		if errors.Is(err, database.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, newChirpResponse(parent))
+		parentID = parent.InReplyTo
+	}
+	slices.Reverse(ancestors)
+	return ancestors, nil
+}
+
+// threadReplies returns c with the replies under it, filled in breadth
+// first. Only replies to c itself start after after.
+func threadReplies(tx database.Tx, c database.Chirp, depth int, limit int, after int) (*ThreadNode, error) {
+	type pending struct {
+		node  *ThreadNode
+		depth int
+		after int
+	}
+	root := newThreadNode(c)
+	queue := []pending{{root, 0, after}}
+	budget := maxThreadChirps
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		replies, err := tx.GetChirpReplies(p.node.ID)
+		if err != nil {
+			return nil, err
+		}
+		p.node.ReplyCount = len(replies)
+		if p.depth == depth {
+			continue
+		}
+		rest := replies[sort.Search(len(replies), func(i int) bool { return replies[i].ID > p.after }):]
+		n := min(len(rest), limit, budget)
+		for _, reply := range rest[:n] {
+			child := newThreadNode(reply)
+			p.node.Replies = append(p.node.Replies, child)
+			queue = append(queue, pending{child, p.depth + 1, 0})
+		}
+		budget -= n
+		if n < len(rest) {
+			next := p.after
+			if n > 0 {
+				next = rest[n-1].ID
+			}
+			p.node.NextAfter = &next
+		}
+	}
+	return root, nil
+}
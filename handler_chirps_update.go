@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+var (
+	errEditWindowClosed = errors.New("The edit window of this chirp has closed.")
+	errRechirpHasNoBody = errors.New("A rechirp has no body to edit.")
+)
+
+// ChirpRevisionResponse is one version of a chirp's body. CreatedAt is when
+// it was written, which is unknown for chirps from before it was recorded.
+type ChirpRevisionResponse struct {
+	Revision  int        `json:"revision"`
+	Body      string     `json:"body"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// handlerUpdateChirp lets the author change the body of their chirp for
+// chirpEditWindow after posting it. The body it replaces is kept in the
+// chirp's history. Sending the current body again changes nothing.
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding parameters: %s", err))
+		return
+	}
+	body, err := cleanChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var resp []ChirpResponse
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		if chirp.AuthorID != principal.UserID {
+			return errForbidden
+		}
+		if chirp.RechirpOf != 0 {
+			return errRechirpHasNoBody
+		}
+		if chirp.QuoteOf != 0 && body == "" {
+			return errEmptyQuote
+		}
+		now := time.Now().UTC()
+		if chirp.CreatedAt == 0 || now.After(time.Unix(chirp.CreatedAt, 0).Add(cfg.chirpEditWindow)) {
+			return errEditWindowClosed
+		}
+		if body == chirp.Body {
+			resp, err = chirpResponses(tx, principal.UserID, chirp)
+			return err
+		}
+		revision := revisionOf(chirp)
+		_, err = tx.CreateChirpRevision(database.ChirpRevision{
+			ChirpID:   chirp.ID,
+			Revision:  revision,
+			Body:      chirp.Body,
+			CreatedAt: bodyWrittenAt(chirp),
+		})
+		if err != nil {
+			return err
+		}
+		chirp.Body = body
+		chirp.EditedAt = now.Unix()
+		chirp.Revisions = revision + 1
+		err = tx.UpdateChirp(chirp)
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, principal.UserID, chirp)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if errors.Is(err, errForbidden) {
+		respondWithError(w, http.StatusForbidden, "Only the author can edit a chirp.")
+		return
+	}
+	if errors.Is(err, errRechirpHasNoBody) || errors.Is(err, errEmptyQuote) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, errEditWindowClosed) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Chirps can only be edited for %s after they are posted.", cfg.chirpEditWindow))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to edit chirp.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp[0])
+}
+
+// handlerGetChirpHistory lists every version of a chirp's body, oldest
+// first, ending with the current one.
+func (cfg *apiConfig) handlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+	var (
+		chirp     database.Chirp
+		revisions []database.ChirpRevision
+	)
+	err = cfg.db.View(func(tx database.Tx) error {
+		chirp, err = tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		revisions, err = tx.GetChirpRevisions(chirpID)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve chirp history.")
+		return
+	}
+
+	resp := make([]ChirpRevisionResponse, 0, len(revisions)+1)
+	for _, rev := range revisions {
+		resp = append(resp, ChirpRevisionResponse{
+			Revision:  rev.Revision,
+			Body:      rev.Body,
+			CreatedAt: unixTimeOrNil(rev.CreatedAt),
+		})
+	}
+	resp = append(resp, ChirpRevisionResponse{
+		Revision:  revisionOf(chirp),
+		Body:      chirp.Body,
+		CreatedAt: unixTimeOrNil(bodyWrittenAt(chirp)),
+	})
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+var errEmailVerified = errors.New("Email address is already verified.")
+
+// handlerRequestEmailVerification sends the signed-in user a new
+// verification email, for their pending address if they have one.
+func (cfg *apiConfig) handlerRequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var user database.User
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		user, err = tx.GetUserByID(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to look up user.")
+		return
+	}
+	email := user.PendingEmail
+	if email == "" {
+		if user.EmailVerified {
+			respondWithError(w, http.StatusConflict, errEmailVerified.Error())
+			return
+		}
+		email = user.Email
+	}
+	err = cfg.sendVerificationEmail(user, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to send verification email.")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerVerifyEmail marks the address a verification token was sent to as
+// verified, if it is still the user's address, or switches the user over to
+// it if it is their pending one.
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	params := struct {
+		Token string `json:"token"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	userID, claims, err := cfg.parseEmailToken(verifyEmailAudience, params.Token)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user database.User
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		if errors.Is(err, database.ErrNotFound) {
+			return errInvalidEmailToken
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case user.PendingEmail != "" && user.PendingEmail == claims.Email:
+			// The address may have been taken since the change was asked for.
+			_, err := tx.GetUser(claims.Email)
+			if err == nil {
+				return errEmailTaken
+			}
+			if !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+			user.Email = user.PendingEmail
+			user.PendingEmail = ""
+		case user.Email == claims.Email:
+			if user.EmailVerified {
+				return errEmailVerified
+			}
+		default:
+			return errInvalidEmailToken
+		}
+		user.EmailVerified = true
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if errors.Is(err, errInvalidEmailToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, errEmailVerified) || errors.Is(err, errEmailTaken) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to verify email address.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// handlerRequestPasswordReset emails a reset link to the address, if a
+// user has it. The answer is the same either way so it can't be used to
+// find out who has an account.
+func (cfg *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	params := struct {
+		Email string `json:"email"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUser(normalizeEmail(params.Email))
+		return err
+	})
+	if err == nil {
+		err = cfg.sendPasswordResetEmail(user)
+	}
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Printf("Unable to send password reset email: %s", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerResetPassword sets a new password with a token from a reset email
+// and signs the user out everywhere.
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	params := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	userID, claims, err := cfg.parseEmailToken(resetPasswordAudience, params.Token)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		return err
+	})
+	if err != nil || passwordFingerprint(user.Password) != claims.PasswordFingerprint {
+		respondWithError(w, http.StatusBadRequest, errInvalidEmailToken.Error())
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, user.Email) {
+		return
+	}
+	hashedPwd, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to hash password.")
+		return
+	}
+
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		// Checked again in the transaction so two requests with the same
+		// token can't both get through.
+		if passwordFingerprint(user.Password) != claims.PasswordFingerprint {
+			return errInvalidEmailToken
+		}
+		user.Password = hashedPwd
+		// Following the link proves the address it was sent to works.
+		if user.Email == claims.Email {
+			user.EmailVerified = true
+		}
+		_, err = tx.UpdateUser(user.ID, user)
+		if err != nil {
+			return err
+		}
+		sessions, err := activeSessions(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			err := tx.RevokeRefreshTokenFamily(s.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errInvalidEmailToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to reset password.")
+		return
+	}
+	cfg.logins.succeed(user.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
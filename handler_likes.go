@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// LikeResponse is a like. Chirp is the chirp liked, where it is shown.
+type LikeResponse struct {
+	ChirpID int            `json:"chirp_id"`
+	LikedAt time.Time      `json:"liked_at"`
+	Chirp   *ChirpResponse `json:"chirp,omitempty"`
+}
+
+func newLikeResponse(l database.Like) LikeResponse {
+	return LikeResponse{
+		ChirpID: l.ChirpID,
+		LikedAt: time.Unix(l.CreatedAt, 0).UTC(),
+	}
+}
+
+// handlerLikeChirp makes the caller like a chirp, or the chirp it reposts
+// if it is a rechirp. Liking it again changes nothing. The answer is the
+// chirp liked with its new like count.
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, true)
+}
+
+// handlerUnlikeChirp takes the caller's like of a chirp back, if there is
+// one. The answer is the chirp with its new like count.
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, false)
+}
+
+// setLike makes the caller like the chirp or not. Checking for the like and
+// changing it happen in one transaction, so racing requests can neither
+// like a chirp twice nor lose a like.
+func (cfg *apiConfig) setLike(w http.ResponseWriter, r *http.Request, like bool) {
+	principal, _ := principalFromContext(r.Context())
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+	var resp []ChirpResponse
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		chirp, err = originalOf(tx, chirp)
+		if err != nil {
+			return err
+		}
+		existing, err := tx.GetLike(principal.UserID, chirp.ID)
+		switch {
+		case err == nil && !like:
+			err = tx.DeleteLike(existing.ID)
+		case errors.Is(err, database.ErrNotFound) && like:
+			_, err = tx.CreateLike(database.Like{
+				UserID:    principal.UserID,
+				ChirpID:   chirp.ID,
+				CreatedAt: time.Now().UTC().Unix(),
+			})
+		case errors.Is(err, database.ErrNotFound):
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, principal.UserID, chirp)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update like.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp[0])
+}
+
+// handlerGetUserLikes lists the chirps a user liked, most recent like
+// first.
+func (cfg *apiConfig) handlerGetUserLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid userID %v", r.PathValue("userID")))
+		return
+	}
+	var resp []LikeResponse
+	err = cfg.db.View(func(tx database.Tx) error {
+		_, err := tx.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		likes, err := tx.GetLikesByUser(userID)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(likes)
+		resp = make([]LikeResponse, 0, len(likes))
+		chirps := make([]*ChirpResponse, 0, len(likes))
+		for _, l := range likes {
+			chirp, err := tx.GetChirp(l.ChirpID)
+			if err != nil {
+				return err
+			}
+			c := newChirpResponse(chirp)
+			like := newLikeResponse(l)
+			like.Chirp = &c
+			resp = append(resp, like)
+			chirps = append(chirps, &c)
+		}
+		return completeChirpResponses(tx, viewerOf(r), chirps...)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("User with ID %v not found", userID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve likes.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
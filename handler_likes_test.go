@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestLikesAndRechirpsAreIdempotent(t *testing.T) {
+	cfg := newTestConfig(t)
+	author := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+	fan := createTestUser(t, cfg, "b@example.com", "chirp-chirp-42")
+	var chirp database.Chirp
+	err := cfg.db.Update(func(tx database.Tx) error {
+		var err error
+		chirp, err = tx.CreateChirp(database.Chirp{AuthorID: author.ID, Body: "hello", Revisions: 1})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rechirpID is the fan's rechirp, once it exists; liking or
+	// rechirping it counts for the chirp it reposts.
+	rechirpID := 0
+
+	steps := []struct {
+		name    string
+		handler http.HandlerFunc
+		// onRechirp sends the request for the fan's rechirp instead of
+		// the chirp.
+		onRechirp    bool
+		want         int
+		wantLikes    int
+		wantRechirps int
+	}{
+		{"like", cfg.handlerLikeChirp, false, http.StatusOK, 1, 0},
+		{"like again", cfg.handlerLikeChirp, false, http.StatusOK, 1, 0},
+		{"rechirp", cfg.handlerRechirp, false, http.StatusCreated, 1, 1},
+		{"rechirp again", cfg.handlerRechirp, false, http.StatusOK, 1, 1},
+		{"rechirp the rechirp", cfg.handlerRechirp, true, http.StatusOK, 1, 1},
+		{"like the rechirp", cfg.handlerLikeChirp, true, http.StatusOK, 1, 1},
+		{"unlike the rechirp", cfg.handlerUnlikeChirp, true, http.StatusOK, 0, 1},
+		{"unlike again", cfg.handlerUnlikeChirp, false, http.StatusOK, 0, 1},
+		{"undo rechirp", cfg.handlerUndoRechirp, false, http.StatusNoContent, 0, 0},
+		{"undo rechirp again", cfg.handlerUndoRechirp, false, http.StatusNoContent, 0, 0},
+	}
+	for _, step := range steps {
+		id := chirp.ID
+		if step.onRechirp {
+			id = rechirpID
+		}
+		r := httptest.NewRequest("PUT", "/", nil)
+		r.SetPathValue("chirpID", strconv.Itoa(id))
+		w := httptest.NewRecorder()
+		step.handler(w, asUser(r, fan))
+		if w.Code != step.want {
+			t.Fatalf("%s: got %d %s, want %d", step.name, w.Code, w.Body.String(), step.want)
+		}
+		if step.want == http.StatusCreated {
+			var resp ChirpResponse
+			decodeResponse(t, w, &resp)
+			rechirpID = resp.ID
+		}
+
+		err := cfg.db.View(func(tx database.Tx) error {
+			likes, err := tx.CountLikes([]int{chirp.ID, rechirpID})
+			if err != nil {
+				return err
+			}
+			if likes[chirp.ID] != step.wantLikes || likes[rechirpID] != 0 {
+				t.Errorf("%s: chirp has %d likes and rechirp %d, want %d and 0", step.name, likes[chirp.ID], likes[rechirpID], step.wantLikes)
+			}
+			rechirps, err := tx.CountRechirps([]int{chirp.ID})
+			if err != nil {
+				return err
+			}
+			if rechirps[chirp.ID] != step.wantRechirps {
+				t.Errorf("%s: chirp has %d rechirps, want %d", step.name, rechirps[chirp.ID], step.wantRechirps)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+var errInvalidCredentials = errors.New("Invalid email and password combination.")
+
+type UserResponseWithToken struct {
+	UserResponse
+	JWTToken     string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email            string `json:"email"`
+		Password         string `json:"password"`
+		ExpiresInSeconds *int64 `json:"expires_in_seconds"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 500, "Unable to parse email and password")
+		return
+	}
+	wait, done := cfg.logins.begin(params.Email, clientIP(r))
+	if wait > 0 {
+		respondLoginThrottled(w, wait)
+		return
+	}
+
+	user, err := cfg.checkPassword(params.Email, params.Password)
+	done(!errors.Is(err, errInvalidCredentials))
+	if errors.Is(err, errInvalidCredentials) {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to verify password.")
+		return
+	}
+
+	// Clients may ask for a shorter-lived access token, never a longer one.
+	expiresIn := accessTokenTTL
+	if params.ExpiresInSeconds != nil && *params.ExpiresInSeconds > 0 {
+		requested := time.Duration(*params.ExpiresInSeconds) * time.Second
+		if requested < expiresIn {
+			expiresIn = requested
+		}
+	}
+
+	// Failed logins are only forgotten once the second factor is in too,
+	// or knowing the password would allow unlimited guesses at codes.
+	if user.TOTPEnabled {
+		cfg.respondMFAChallenge(w, user, expiresIn)
+		return
+	}
+	cfg.logins.succeed(params.Email)
+	cfg.completeLogin(w, r, user, expiresIn)
+}
+
+// checkPassword looks up the user with email and checks their password.
+// Unknown emails and wrong passwords both give errInvalidCredentials,
+// after the same amount of work; callers count it against the login
+// throttle.
+func (cfg *apiConfig) checkPassword(email string, password string) (database.User, error) {
+	var user database.User
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		user, err = tx.GetUser(normalizeEmail(email))
+		return err
+	})
+	// Users who only sign in through an identity provider have no
+	// password; they are treated like unknown emails.
+	if errors.Is(err, database.ErrNotFound) || (err == nil && user.Password == "") {
+		cfg.passwords.Verify(cfg.dummyHash, password)
+		return database.User{}, errInvalidCredentials
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	err = cfg.passwords.Verify(user.Password, password)
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		return database.User{}, errInvalidCredentials
+	}
+	if err != nil {
+		log.Printf("Unable to verify password of user %d: %s", user.ID, err)
+		return database.User{}, err
+	}
+	if cfg.passwords.NeedsRehash(user.Password) {
+		cfg.rehashPassword(user, password)
+	}
+	return user, nil
+}
+
+// completeLogin starts a session for user and answers with their tokens.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User, expiresIn time.Duration) {
+	signedToken, err := cfg.issueAccessToken(user, expiresIn)
+	if err != nil {
+		log.Println(err.Error())
+		respondWithError(w, 500, "Unable to get JWT Token")
+		return
+	}
+	var refreshToken string
+	err = cfg.db.Update(func(tx database.Tx) error {
+		refreshToken, _, err = issueRefreshToken(tx, database.RefreshToken{UserID: user.ID}, r)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, 500, "Internal Error")
+		return
+	}
+
+	respondWithJSON(w, 200, UserResponseWithToken{
+		UserResponse: newUserResponse(user),
+		JWTToken:     signedToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// rehashPassword stores password under the current hashing scheme. It is
+// called right after password was checked against user's old hash. Failing
+// to upgrade is logged and does not fail the login.
+func (cfg *apiConfig) rehashPassword(user database.User, password string) {
+	hashed, err := cfg.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Unable to rehash password of user %d: %s", user.ID, err)
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		current, err := tx.GetUserByID(user.ID)
+		if err != nil {
+			return err
+		}
+		// The password changed since it was checked; keep the new one.
+		if current.Password != user.Password {
+			return nil
+		}
+		current.Password = hashed
+		_, err = tx.UpdateUser(user.ID, current)
+		return err
+	})
+	if err != nil {
+		log.Printf("Unable to rehash password of user %d: %s", user.ID, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginRehashesBcrypt(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		want       int
+		wantArgon2 bool
+	}{
+		{"right password", "chirp-chirp-42", http.StatusOK, true},
+		{"wrong password", "chirp-chirp-43", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			old, err := bcrypt.GenerateFromPassword([]byte("chirp-chirp-42"), bcrypt.MinCost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := storeTestUser(t, cfg, "a@example.com", string(old))
+
+			body := fmt.Sprintf(`{"email":"a@example.com","password":%q}`, tt.password)
+			w := httptest.NewRecorder()
+			cfg.handlerLogin(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(body)))
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+
+			var stored database.User
+			err = cfg.db.View(func(tx database.Tx) error {
+				stored, err = tx.GetUserByID(user.ID)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantArgon2 {
+				if stored.Password != string(old) {
+					t.Errorf("hash changed to %q", stored.Password)
+				}
+				return
+			}
+			if !strings.HasPrefix(stored.Password, "$argon2id$") || cfg.passwords.NeedsRehash(stored.Password) {
+				t.Errorf("hash was not upgraded: %q", stored.Password)
+			}
+			err = cfg.passwords.Verify(stored.Password, "chirp-chirp-42")
+			if err != nil {
+				t.Errorf("upgraded hash does not verify: %s", err)
+			}
+		})
+	}
+}
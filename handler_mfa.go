@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	totpIssuer = "Chirpy"
+	// mfaAudience keeps MFA challenge tokens from being accepted as
+	// access tokens.
+	mfaAudience       = "chirpy-mfa"
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidMFACode  = errors.New("Invalid two-factor code.")
+	errMFAEnabled      = errors.New("Two-factor authentication is already enabled.")
+	errMFANotEnabled   = errors.New("Two-factor authentication is not enabled.")
+	errMFANotEnrolling = errors.New("Start two-factor enrollment first.")
+	errMFAThrottled    = errors.New("Too many failed attempts. Try again later.")
+)
+
+// mfaClaims are the claims of an MFA challenge token, which stands for a
+// correct password until the second factor is checked.
+type mfaClaims struct {
+	jwt.RegisteredClaims
+	AccessTTL int64 `json:"access_ttl"`
+}
+
+func (cfg *apiConfig) respondMFAChallenge(w http.ResponseWriter, user database.User, expiresIn time.Duration) {
+	now := time.Now().UTC()
+	token, err := cfg.keys.Sign(mfaClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+			Subject:   strconv.Itoa(user.ID),
+		},
+		AccessTTL: int64(expiresIn.Seconds()),
+	})
+	if err != nil {
+		log.Println(err.Error())
+		respondWithError(w, 500, "Unable to get JWT Token")
+		return
+	}
+	respondWithJSON(w, 200, struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{true, token, int(mfaChallengeTTL.Seconds())})
+}
+
+func (cfg *apiConfig) parseMFAToken(tokenString string) (int, time.Duration, error) {
+	token, err := cfg.keys.Parse(tokenString, &mfaClaims{})
+	if err != nil {
+		return 0, 0, err
+	}
+	claims := token.Claims.(*mfaClaims)
+	if claims.ExpiresAt == nil || !claims.VerifyIssuer(jwtIssuer, true) || !claims.VerifyAudience(mfaAudience, true) {
+		return 0, 0, errors.New("Not an MFA challenge token")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid token subject %q", claims.Subject)
+	}
+	expiresIn := time.Duration(claims.AccessTTL) * time.Second
+	if expiresIn <= 0 || expiresIn > accessTokenTTL {
+		expiresIn = accessTokenTTL
+	}
+	return userID, expiresIn, nil
+}
+
+// useSecondFactor checks code, either a TOTP code or a recovery code,
+// against user and uses it up so it can't be presented again. The caller
+// has to store user afterwards.
+func useSecondFactor(user *database.User, code string) bool {
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if ok && step > user.TOTPLastStep {
+		user.TOTPLastStep = step
+		return true
+	}
+	i := slices.Index(user.RecoveryCodes, auth.HashRecoveryCode(code))
+	if i < 0 {
+		return false
+	}
+	user.RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+	return true
+}
+
+// useSecondFactorThrottled is useSecondFactor for a signed-in user, counted
+// against the same failed-login limits as logging in, so a stolen access
+// token can't be used to guess codes.
+func (cfg *apiConfig) useSecondFactorThrottled(user *database.User, code string, ip string) error {
+	wait, done := cfg.logins.begin(user.Email, ip)
+	if wait > 0 {
+		return errMFAThrottled
+	}
+	ok := useSecondFactor(user, code)
+	done(ok)
+	if !ok {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+func decodeMFACode(r *http.Request) (string, error) {
+	params := struct {
+		Code string `json:"code"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		return "", err
+	}
+	return params.Code, nil
+}
+
+func respondMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidMFACode):
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, errMFAThrottled):
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, errMFAEnabled):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errMFANotEnabled), errors.Is(err, errMFANotEnrolling):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Unable to update two-factor authentication.")
+	}
+}
+
+// handlerLoginMFA finishes a login that was answered with an MFA challenge.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	params := struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	userID, expiresIn, err := cfg.parseMFAToken(params.MFAToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token.")
+		return
+	}
+
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token.")
+		return
+	}
+	wait, done := cfg.logins.begin(user.Email, clientIP(r))
+	if wait > 0 {
+		respondLoginThrottled(w, wait)
+		return
+	}
+
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled || !useSecondFactor(&user, params.Code) {
+			return errInvalidMFACode
+		}
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	done(!errors.Is(err, errInvalidMFACode))
+	if errors.Is(err, errInvalidMFACode) {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to verify two-factor code.")
+		return
+	}
+	cfg.logins.succeed(user.Email)
+	cfg.completeLogin(w, r, user, expiresIn)
+}
+
+// handlerEnrollTOTP starts two-factor enrollment with a new secret. It only
+// takes effect once a code from it is confirmed. It takes the current
+// password so a stolen access token alone can't enroll an authenticator of
+// the thief's and lock the user out of their own second factor.
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to look up user.")
+		return
+	}
+	if user.Password == "" {
+		respondWithError(w, http.StatusConflict, "Your account has no password yet. Set one with a password reset first.")
+		return
+	}
+
+	// Guesses at the current password count as failed logins.
+	wait, done := cfg.logins.begin(user.Email, clientIP(r))
+	if wait > 0 {
+		respondLoginThrottled(w, wait)
+		return
+	}
+	err = cfg.passwords.Verify(user.Password, params.CurrentPassword)
+	done(!errors.Is(err, auth.ErrPasswordMismatch))
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		respondWithError(w, http.StatusUnauthorized, "Current password is incorrect.")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to verify password of user %d: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to verify password.")
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create TOTP secret.")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return errMFAEnabled
+		}
+		user.TOTPSecret = secret
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if err != nil {
+		respondMFAError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{secret, auth.TOTPURI(totpIssuer, user.Email, secret)})
+}
+
+// handlerConfirmTOTP turns two-factor authentication on once the user shows
+// a valid code, and hands out recovery codes. They are shown only once.
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	code, err := decodeMFACode(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create recovery codes.")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err := tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return errMFAEnabled
+		}
+		if user.TOTPSecret == "" {
+			return errMFANotEnrolling
+		}
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return errInvalidMFACode
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = hashes
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if err != nil {
+		respondMFAError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// handlerRegenerateRecoveryCodes replaces every recovery code with new ones.
+func (cfg *apiConfig) handlerRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	code, err := decodeMFACode(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create recovery codes.")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err := tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return errMFANotEnabled
+		}
+		err = cfg.useSecondFactorThrottled(&user, code, clientIP(r))
+		if err != nil {
+			return err
+		}
+		user.RecoveryCodes = hashes
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if err != nil {
+		respondMFAError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// handlerDisableTOTP turns two-factor authentication off. It takes a
+// current code so a stolen access token alone can't do it.
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	code, err := decodeMFACode(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err := tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return errMFANotEnabled
+		}
+		err = cfg.useSecondFactorThrottled(&user, code, clientIP(r))
+		if err != nil {
+			return err
+		}
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if err != nil {
+		respondMFAError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestEnrollTOTPNeedsCurrentPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// setup changes the user before they enroll.
+		setup      func(user *database.User)
+		want       int
+		wantSecret bool
+	}{
+		{"right password", `{"current_password":"chirp-chirp-42"}`, nil, http.StatusOK, true},
+		{"wrong password", `{"current_password":"chirp-chirp-43"}`, nil, http.StatusUnauthorized, false},
+		{"no password", `{}`, nil, http.StatusUnauthorized, false},
+		{"no body", ``, nil, http.StatusBadRequest, false},
+		{"already enabled", `{"current_password":"chirp-chirp-42"}`, func(user *database.User) {
+			user.TOTPEnabled = true
+			user.TOTPSecret = "existing"
+		}, http.StatusConflict, false},
+		{"account without a password", `{"current_password":""}`, func(user *database.User) {
+			user.Password = ""
+		}, http.StatusConflict, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+			if tt.setup != nil {
+				tt.setup(&user)
+				err := cfg.db.Update(func(tx database.Tx) error {
+					_, err := tx.UpdateUser(user.ID, user)
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := asUser(httptest.NewRequest("POST", "/api/mfa/totp", strings.NewReader(tt.body)), user)
+			w := httptest.NewRecorder()
+			cfg.handlerEnrollTOTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+
+			var stored database.User
+			err := cfg.db.View(func(tx database.Tx) error {
+				var err error
+				stored, err = tx.GetUserByID(user.ID)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantSecret {
+				var resp struct {
+					Secret string `json:"secret"`
+				}
+				decodeResponse(t, w, &resp)
+				if resp.Secret == "" || stored.TOTPSecret != resp.Secret {
+					t.Errorf("answered secret %q and stored %q", resp.Secret, stored.TOTPSecret)
+				}
+				return
+			}
+			if stored.TOTPSecret != user.TOTPSecret {
+				t.Errorf("secret changed to %q", stored.TOTPSecret)
+			}
+		})
+	}
+}
+
+func TestEnrollTOTPThrottlesPasswordGuesses(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+	enroll := func(password string) int {
+		body := fmt.Sprintf(`{"current_password":%q}`, password)
+		r := asUser(httptest.NewRequest("POST", "/api/mfa/totp", strings.NewReader(body)), user)
+		w := httptest.NewRecorder()
+		cfg.handlerEnrollTOTP(w, r)
+		return w.Code
+	}
+	for i := 0; i <= accountLoginLimit.freeAttempts; i++ {
+		if got := enroll("chirp-chirp-43"); got != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got %d, want %d", i+1, got, http.StatusUnauthorized)
+		}
+	}
+	if got := enroll("chirp-chirp-42"); got != http.StatusTooManyRequests {
+		t.Errorf("right password after too many guesses: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/bigbabyjack/chirpy/oidc"
+)
+
+var (
+	errUnverifiedIdentity = errors.New("The provider did not vouch for an email address.")
+	errLastSignInMethod   = errors.New("Set a password or link another identity before unlinking this one.")
+)
+
+// IdentityResponse is a linked identity as shown to its user.
+type IdentityResponse struct {
+	ID         int        `json:"id"`
+	Provider   string     `json:"provider"`
+	Subject    string     `json:"subject"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (cfg *apiConfig) newIdentityResponse(i database.Identity) IdentityResponse {
+	return IdentityResponse{
+		ID:         i.ID,
+		Provider:   cfg.providerName(i.Issuer),
+		Subject:    i.Subject,
+		Email:      i.Email,
+		CreatedAt:  time.Unix(i.CreatedAt, 0).UTC(),
+		LastUsedAt: unixTimeOrNil(i.LastUsedAt),
+	}
+}
+
+func (cfg *apiConfig) oidcProvider(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	p, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Unknown identity provider %q", r.PathValue("provider")))
+	}
+	return p, ok
+}
+
+// handlerGetOIDCProviders lists the providers users can sign in with.
+func (cfg *apiConfig) handlerGetOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range cfg.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	respondWithJSON(w, http.StatusOK, names)
+}
+
+// handlerOIDCLogin sends the user to the provider to sign in.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to start sign-in.")
+		return
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to start sign-in.")
+		return
+	}
+	verifier, err := randomHex(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to start sign-in.")
+		return
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	authURL, err := p.AuthCodeURL(r.Context(), state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		log.Println(err)
+		respondWithError(w, http.StatusBadGateway, "Unable to reach the identity provider.")
+		return
+	}
+	cookie, err := cfg.issueOIDCState(oidcStateClaims{
+		Provider: p.Name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to start sign-in.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		Secure:   strings.HasPrefix(cfg.apiURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes signing in when the provider sends the user
+// back, and answers like POST /api/login. The identity is found by the
+// provider's subject; the first time, it is linked to the user with the
+// email address the provider vouched for, who is created if there is none.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+	// The state is single-use whatever happens next.
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/", MaxAge: -1})
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("The identity provider refused the sign-in: %s", e))
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Sign-in was not started here or took too long.")
+		return
+	}
+	state, err := cfg.parseOIDCState(cookie.Value)
+	if err != nil || state.Provider != p.Name || subtle.ConstantTimeCompare([]byte(state.State), []byte(q.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Sign-in was not started here or took too long.")
+		return
+	}
+	idToken, err := p.Exchange(r.Context(), q.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC sign-in with %s failed: %s", p.Name, err)
+		respondWithError(w, http.StatusUnauthorized, "The identity provider's answer could not be verified.")
+		return
+	}
+
+	var user database.User
+	err = cfg.db.Update(func(tx database.Tx) error {
+		var err error
+		user, err = linkIdentity(tx, p.Issuer, idToken)
+		return err
+	})
+	if errors.Is(err, errUnverifiedIdentity) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to sign in.")
+		return
+	}
+	if user.TOTPEnabled {
+		cfg.respondMFAChallenge(w, user, accessTokenTTL)
+		return
+	}
+	cfg.completeLogin(w, r, user, accessTokenTTL)
+}
+
+// linkIdentity returns the user idToken belongs to, linking it first if
+// it is new.
+func linkIdentity(tx database.Tx, issuer string, idToken *oidc.IDToken) (database.User, error) {
+	now := time.Now().UTC().Unix()
+	email := normalizeEmail(idToken.Email)
+	identity, err := tx.GetIdentity(issuer, idToken.Subject)
+	if err == nil {
+		identity.LastUsedAt = now
+		if email != "" && idToken.EmailVerified {
+			identity.Email = email
+		}
+		err = tx.UpdateIdentity(identity)
+		if err != nil {
+			return database.User{}, err
+		}
+		return tx.GetUserByID(identity.UserID)
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return database.User{}, err
+	}
+
+	if email == "" || !idToken.EmailVerified {
+		return database.User{}, errUnverifiedIdentity
+	}
+	user, err := tx.GetUser(email)
+	if errors.Is(err, database.ErrNotFound) {
+		user, err = tx.CreateUser(email, "")
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	if !user.EmailVerified {
+		err = resetUnprovenAccount(tx, &user)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	_, err = tx.CreateIdentity(database.Identity{
+		UserID:     user.ID,
+		Issuer:     issuer,
+		Subject:    idToken.Subject,
+		Email:      email,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// resetUnprovenAccount is called when a provider vouches for the address
+// of an account that never verified it. Whoever set the account up never
+// proved they own the address, so they might not be the person signing in
+// now: their password, second factor, sessions and API keys are dropped
+// before the account is handed over.
+func resetUnprovenAccount(tx database.Tx, user *database.User) error {
+	user.Password = ""
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	user.PendingEmail = ""
+	user.EmailVerified = true
+	_, err := tx.UpdateUser(user.ID, *user)
+	if err != nil {
+		return err
+	}
+	return revokeAllCredentials(tx, user.ID)
+}
+
+// handlerGetIdentities lists the identities linked to the caller.
+func (cfg *apiConfig) handlerGetIdentities(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var identities []database.Identity
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		identities, err = tx.GetIdentitiesByUser(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve identities.")
+		return
+	}
+	resp := make([]IdentityResponse, 0, len(identities))
+	for _, i := range identities {
+		resp = append(resp, cfg.newIdentityResponse(i))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerDeleteIdentity unlinks one of the caller's identities, unless it
+// is their only way left to sign in.
+func (cfg *apiConfig) handlerDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	identityID, err := strconv.Atoi(r.PathValue("identityID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid identityID %v", r.PathValue("identityID")))
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		identity, err := tx.GetIdentityByID(identityID)
+		if err != nil {
+			return err
+		}
+		// Someone else's identity is reported as missing so IDs can't be
+		// probed.
+		if identity.UserID != principal.UserID {
+			return database.ErrNotFound
+		}
+		user, err := tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		identities, err := tx.GetIdentitiesByUser(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Password == "" && len(identities) < 2 {
+			return errLastSignInMethod
+		}
+		return tx.DeleteIdentity(identityID)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Identity with ID %v not found", identityID))
+		return
+	}
+	if errors.Is(err, errLastSignInMethod) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to unlink identity.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
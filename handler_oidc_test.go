@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/bigbabyjack/chirpy/oidc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestLinkIdentity(t *testing.T) {
+	const issuer = "https://idp.example.com"
+	tests := []struct {
+		name string
+		// accountVerified is whether the existing password account proved
+		// it owns a@example.com.
+		accountVerified bool
+		email           string
+		emailVerified   bool
+		wantErr         error
+		// wantReset is whether the existing account is handed over without
+		// its password and sessions.
+		wantReset bool
+	}{
+		{"unproven account", false, "a@example.com", true, nil, true},
+		{"unproven account, other case", false, " A@Example.COM", true, nil, true},
+		{"verified account", true, "A@example.com", true, nil, false},
+		{"new user", false, "b@example.com", true, nil, false},
+		{"email not vouched for", false, "a@example.com", false, errUnverifiedIdentity, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			account := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+			err := cfg.db.Update(func(tx database.Tx) error {
+				account.EmailVerified = tt.accountVerified
+				account.TOTPEnabled = true
+				account.TOTPSecret = "secret"
+				_, err := tx.UpdateUser(account.ID, account)
+				if err != nil {
+					return err
+				}
+				_, _, err = issueRefreshToken(tx, database.RefreshToken{UserID: account.ID}, httptest.NewRequest("POST", "/api/login", nil))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var user database.User
+			err = cfg.db.Update(func(tx database.Tx) error {
+				var err error
+				user, err = linkIdentity(tx, issuer, &oidc.IDToken{
+					RegisteredClaims: jwt.RegisteredClaims{Subject: "subject"},
+					Email:            tt.email,
+					EmailVerified:    tt.emailVerified,
+				})
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("linkIdentity returned %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			wantEmail := normalizeEmail(tt.email)
+			if user.Email != wantEmail {
+				t.Errorf("linked to %q, want %q", user.Email, wantEmail)
+			}
+			if (user.ID == account.ID) != (wantEmail == account.Email) {
+				t.Errorf("linked to user %d, account is %d", user.ID, account.ID)
+			}
+			err = cfg.db.View(func(tx database.Tx) error {
+				identity, err := tx.GetIdentity(issuer, "subject")
+				if err != nil {
+					return err
+				}
+				if identity.UserID != user.ID || identity.Email != wantEmail {
+					t.Errorf("identity is for user %d with %q", identity.UserID, identity.Email)
+				}
+				account, err := tx.GetUserByID(account.ID)
+				if err != nil {
+					return err
+				}
+				if reset := account.Password == ""; reset != tt.wantReset {
+					t.Errorf("password dropped = %v, want %v", reset, tt.wantReset)
+				}
+				if reset := !account.TOTPEnabled; reset != tt.wantReset {
+					t.Errorf("second factor dropped = %v, want %v", reset, tt.wantReset)
+				}
+				sessions, err := activeSessions(tx, account.ID)
+				if err != nil {
+					return err
+				}
+				if reset := len(sessions) == 0; reset != tt.wantReset {
+					t.Errorf("sessions revoked = %v, want %v", reset, tt.wantReset)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
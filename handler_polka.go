@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		respondWithError(w, 401, "Don't recognize api key")
+		return
+	}
+
+	polkaAPIKey := strings.TrimPrefix(authHeader, "ApiKey ")
+	if cfg.polkaApiKey != polkaAPIKey {
+		respondWithError(w, 401, "Don't recognize api key")
+		return
+	}
+	type polkaHook struct {
+		Event string         `json:"event"`
+		Data  map[string]int `json:"data"`
+	}
+
+	request := &polkaHook{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(request)
+	if err != nil {
+		respondWithError(w, 404, err.Error())
+		return
+	}
+	if request.Event != "user.upgraded" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	userID, ok := request.Data["user_id"]
+	if !ok {
+		respondWithError(w, 404, "")
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err := tx.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		user.IsChirpyRed = true
+		_, err = tx.UpdateUser(userID, user)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, 404, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
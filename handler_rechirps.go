@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// handlerRechirp reposts a chirp for the caller, or the chirp it reposts if
+// it is a rechirp. Rechirping it again changes nothing. The answer is the
+// caller's rechirp.
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+	status := http.StatusOK
+	var resp []ChirpResponse
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		original, err := originalOf(tx, chirp)
+		if err != nil {
+			return err
+		}
+		rechirp, err := tx.GetRechirp(principal.UserID, original.ID)
+		if errors.Is(err, database.ErrNotFound) {
+			status = http.StatusCreated
+			rechirp, err = tx.CreateChirp(database.Chirp{
+				AuthorID:  principal.UserID,
+				CreatedAt: time.Now().UTC().Unix(),
+				Revisions: 1,
+				RechirpOf: original.ID,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		resp, err = chirpResponses(tx, principal.UserID, rechirp)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to rechirp.")
+		return
+	}
+	respondWithJSON(w, status, resp[0])
+}
+
+// handlerUndoRechirp deletes the caller's rechirp of a chirp, if there is
+// one.
+func (cfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", r.PathValue("chirpID")))
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		chirp, err := tx.GetChirp(chirpID)
+		if err != nil {
+			return err
+		}
+		original, err := originalOf(tx, chirp)
+		if err != nil {
+			return err
+		}
+		rechirp, err := tx.GetRechirp(principal.UserID, original.ID)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.DeleteChirp(rechirp.ID)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Chirp with ID %v not found", chirpID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to undo rechirp.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
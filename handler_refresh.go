@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+var errInvalidRefreshToken = errors.New("Invalid refresh token.")
+
+// hashRefreshToken is how refresh tokens are stored and looked up. The
+// tokens are 256 random bits, so a plain SHA-256 is enough.
+func hashRefreshToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken creates a new refresh token carrying the UserID,
+// FamilyID, ClientID and Scopes of grant and returns the raw token, which
+// is never stored. A FamilyID of 0 starts a new session.
+func issueRefreshToken(tx database.Tx, grant database.RefreshToken, r *http.Request) (string, database.RefreshToken, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", database.RefreshToken{}, err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now().UTC()
+	t, err := tx.CreateRefreshToken(database.RefreshToken{
+		UserID:    grant.UserID,
+		TokenHash: hashRefreshToken(token),
+		FamilyID:  grant.FamilyID,
+		ClientID:  grant.ClientID,
+		Scopes:    grant.Scopes,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(refreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", database.RefreshToken{}, err
+	}
+	return token, t, nil
+}
+
+// rotateRefreshToken exchanges the raw refresh token for a new one in the
+// same family and returns the new raw token and the old record. Only
+// tokens issued to clientID are accepted; first-party tokens have none.
+// Presenting a token that was already exchanged means it was copied, so
+// the whole family is revoked and reused is true; the caller must still
+// commit the transaction for that to stick.
+func rotateRefreshToken(tx database.Tx, raw string, clientID string, r *http.Request) (token string, old database.RefreshToken, reused bool, err error) {
+	t, err := tx.GetRefreshToken(hashRefreshToken(raw))
+	if errors.Is(err, database.ErrNotFound) {
+		return "", database.RefreshToken{}, false, errInvalidRefreshToken
+	}
+	if err != nil {
+		return "", database.RefreshToken{}, false, err
+	}
+	if t.ClientID != clientID {
+		return "", database.RefreshToken{}, false, errInvalidRefreshToken
+	}
+	if t.ReplacedBy != 0 && t.RevokedAt == 0 {
+		return "", t, true, tx.RevokeRefreshTokenFamily(t.FamilyID)
+	}
+	if !t.Active(time.Now().UTC()) {
+		return "", database.RefreshToken{}, false, errInvalidRefreshToken
+	}
+	token, next, err := issueRefreshToken(tx, t, r)
+	if err != nil {
+		return "", database.RefreshToken{}, false, err
+	}
+	t.ReplacedBy = next.ID
+	err = tx.UpdateRefreshToken(t)
+	if err != nil {
+		return "", database.RefreshToken{}, false, err
+	}
+	return token, t, false, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handlerRefresh exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working. Presenting a token
+// that was already exchanged means it was copied, so the whole session is
+// revoked.
+func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := getBearerTokenFromHeader(r)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+
+	var user database.User
+	var newRefreshToken string
+	reused := false
+	err = cfg.db.Update(func(tx database.Tx) error {
+		var t database.RefreshToken
+		var err error
+		newRefreshToken, t, reused, err = rotateRefreshToken(tx, refreshToken, "", r)
+		if err != nil || reused {
+			return err
+		}
+		// Look the user up again so a changed role shows in the new
+		// access token.
+		user, err = tx.GetUserByID(t.UserID)
+		return err
+	})
+	if reused && err == nil {
+		log.Printf("Refresh token reuse detected from %s, session revoked", clientIP(r))
+		respondWithError(w, 401, "Unauthorized user.")
+		return
+	}
+	if errors.Is(err, database.ErrNotFound) || errors.Is(err, errInvalidRefreshToken) {
+		respondWithError(w, 401, "Unauthorized user.")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Internal Error")
+		return
+	}
+
+	signedToken, err := cfg.issueAccessToken(user, accessTokenTTL)
+	if err != nil {
+		respondWithError(w, 500, "Unable to get JWT Token")
+		return
+	}
+	respondWithJSON(w, 200, struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{signedToken, newRefreshToken})
+}
+
+// handlerRevoke ends the session the presented refresh token belongs to.
+func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := getBearerTokenFromHeader(r)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		t, err := tx.GetRefreshToken(hashRefreshToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		return tx.RevokeRefreshTokenFamily(t.FamilyID)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, 401, "Invalid token")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Internal Error")
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	cfg := newTestConfig(t)
+	tokens := map[string]string{}
+	err := cfg.db.Update(func(tx database.Tx) error {
+		user, err := tx.CreateUser("a@example.com", "hash")
+		if err != nil {
+			return err
+		}
+		tokens["login"], _, err = issueRefreshToken(tx, database.RefreshToken{UserID: user.ID}, httptest.NewRequest("POST", "/api/login", nil))
+		if err != nil {
+			return err
+		}
+		// Another session of the same user must survive.
+		tokens["other"], _, err = issueRefreshToken(tx, database.RefreshToken{UserID: user.ID}, httptest.NewRequest("POST", "/api/login", nil))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		present string
+		want    int
+		// save names the refresh token handed out, if any.
+		save string
+	}{
+		{"first refresh", "login", http.StatusOK, "second"},
+		{"second refresh", "second", http.StatusOK, "third"},
+		{"replay first token", "login", http.StatusUnauthorized, ""},
+		{"current token after replay", "third", http.StatusUnauthorized, ""},
+		{"other session", "other", http.StatusOK, ""},
+	}
+	for _, step := range steps {
+		r := httptest.NewRequest("POST", "/api/refresh", nil)
+		r.Header.Set("Authorization", "Bearer "+tokens[step.present])
+		w := httptest.NewRecorder()
+		cfg.handlerRefresh(w, r)
+		if w.Code != step.want {
+			t.Fatalf("%s: got %d %s, want %d", step.name, w.Code, w.Body.String(), step.want)
+		}
+		if step.save != "" {
+			var resp struct {
+				RefreshToken string `json:"refresh_token"`
+			}
+			decodeResponse(t, w, &resp)
+			tokens[step.save] = resp.RefreshToken
+		}
+	}
+
+	err = cfg.db.View(func(tx database.Tx) error {
+		for _, name := range []string{"login", "second", "third"} {
+			token, err := tx.GetRefreshToken(hashRefreshToken(tokens[name]))
+			if err != nil {
+				return err
+			}
+			if token.RevokedAt == 0 {
+				t.Errorf("%s token was not revoked", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
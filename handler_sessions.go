@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// Session is one login on one device. Its ID is the refresh token family,
+// so it stays the same while the refresh token rotates.
+type Session struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	// ClientID is set for sessions of third-party apps.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// activeSessions collapses the user's refresh tokens into one Session per
+// family that still has a usable token.
+func activeSessions(tx database.Tx, userID int) ([]Session, error) {
+	tokens, err := tx.GetRefreshTokensByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	started := make(map[int]int64)
+	for _, t := range tokens {
+		if t.ID == t.FamilyID {
+			started[t.FamilyID] = t.CreatedAt
+		}
+	}
+	now := time.Now().UTC()
+	sessions := []Session{}
+	for _, t := range tokens {
+		if !t.Active(now) {
+			continue
+		}
+		// The current token of a family was issued the last time the
+		// session was used.
+		sessions = append(sessions, Session{
+			ID:         t.FamilyID,
+			CreatedAt:  time.Unix(started[t.FamilyID], 0).UTC(),
+			LastUsedAt: time.Unix(t.CreatedAt, 0).UTC(),
+			ExpiresAt:  time.Unix(t.ExpiresAt, 0).UTC(),
+			UserAgent:  t.UserAgent,
+			IP:         t.IP,
+			ClientID:   t.ClientID,
+		})
+	}
+	return sessions, nil
+}
+
+func (cfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	var sessions []Session
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		sessions, err = activeSessions(tx, userID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, 500, "Unable to retrieve sessions.")
+		return
+	}
+	respondWithJSON(w, 200, sessions)
+}
+
+func (cfg *apiConfig) handlerDeleteSession(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	sessionID, err := strconv.Atoi(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid sessionID %v", r.PathValue("sessionID")))
+		return
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		t, err := tx.GetRefreshTokenByID(sessionID)
+		if err != nil {
+			return err
+		}
+		// Someone else's session is reported as missing rather than
+		// forbidden so session IDs can't be probed.
+		if t.UserID != userID || t.FamilyID != sessionID {
+			return database.ErrNotFound
+		}
+		return tx.RevokeRefreshTokenFamily(sessionID)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, 404, fmt.Sprintf("Session with ID %v not found", sessionID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to revoke session.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerDeleteSessions logs the user out everywhere.
+func (cfg *apiConfig) handlerDeleteSessions(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	userID := principal.UserID
+	err := cfg.db.Update(func(tx database.Tx) error {
+		sessions, err := activeSessions(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			err := tx.RevokeRefreshTokenFamily(s.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, 500, "Unable to revoke sessions.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/bigbabyjack/chirpy/mail"
+)
+
+type UserResponse struct {
+	ID            int      `json:"id"`
+	Email         string   `json:"email"`
+	IsChirpyRed   bool     `json:"is_chirpy_red"`
+	Role          string   `json:"role"`
+	Scopes        []string `json:"scopes"`
+	MFAEnabled    bool     `json:"mfa_enabled"`
+	EmailVerified bool     `json:"email_verified"`
+	PendingEmail  string   `json:"pending_email,omitempty"`
+	// DeleteAfter is set while the account is waiting to be deleted.
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+}
+
+func newUserResponse(user database.User) UserResponse {
+	return UserResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          roleOf(user),
+		Scopes:        scopesFor(user),
+		MFAEnabled:    user.TOTPEnabled,
+		EmailVerified: user.EmailVerified,
+		PendingEmail:  user.PendingEmail,
+		DeleteAfter:   unixTimeOrNil(user.DeleteAfter),
+	}
+}
+
+// checkPasswordPolicy answers 400 with every rule password breaks and
+// returns false if it may not be used.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password string, email string) bool {
+	violations := cfg.passwordPolicy.Check(password, email)
+	if len(violations) == 0 {
+		return true
+	}
+	respondWithJSON(w, http.StatusBadRequest, struct {
+		Error      string           `json:"error"`
+		Violations []auth.Violation `json:"violations"`
+	}{"Password does not meet the password policy.", violations})
+	return false
+}
+
+func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 500, "Error decoding parameters.")
+		return
+	}
+	params.Email = normalizeEmail(params.Email)
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address.")
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
+	hashedPwd, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, 500, "Unable to hash password.")
+		return
+	}
+	var user database.User
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.CreateUser(params.Email, hashedPwd)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, 500, "Error creating user.")
+		return
+	}
+	err = cfg.sendVerificationEmail(user, user.Email)
+	if err != nil {
+		log.Printf("Unable to send verification email to user %d: %s", user.ID, err)
+	}
+	respondWithJSON(w, 201, newUserResponse(user))
+}
+
+var errEmailTaken = errors.New("Email address is already in use.")
+
+// handlerUpdateUser changes the signed-in user's email address or password.
+// Nothing else about a user can be changed here, and both changes need the
+// current password so an access token alone isn't enough. A new email
+// address only takes effect once it is verified.
+func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding parameters: %s", err))
+		return
+	}
+	if params.Email == nil && params.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update.")
+		return
+	}
+
+	var user database.User
+	err = cfg.db.View(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to look up user.")
+		return
+	}
+
+	if user.Password == "" {
+		respondWithError(w, http.StatusConflict, "Your account has no password yet. Set one with a password reset first.")
+		return
+	}
+
+	// Guesses at the current password count as failed logins.
+	wait, done := cfg.logins.begin(user.Email, clientIP(r))
+	if wait > 0 {
+		respondLoginThrottled(w, wait)
+		return
+	}
+	err = cfg.passwords.Verify(user.Password, params.CurrentPassword)
+	done(!errors.Is(err, auth.ErrPasswordMismatch))
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		respondWithError(w, http.StatusUnauthorized, "Current password is incorrect.")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to verify password of user %d: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to verify password.")
+		return
+	}
+
+	newEmail := ""
+	if params.Email != nil && normalizeEmail(*params.Email) != normalizeEmail(user.Email) {
+		newEmail = normalizeEmail(*params.Email)
+		if !validEmail(newEmail) {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address.")
+			return
+		}
+	}
+	hashedPwd := ""
+	if params.Password != nil {
+		email := user.Email
+		if newEmail != "" {
+			email = newEmail
+		}
+		if !cfg.checkPasswordPolicy(w, *params.Password, email) {
+			return
+		}
+		hashedPwd, err = cfg.passwords.Hash(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Unable to hash password.")
+			return
+		}
+	}
+
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(principal.UserID)
+		if err != nil {
+			return err
+		}
+		if newEmail != "" {
+			_, err := tx.GetUser(newEmail)
+			if err == nil {
+				return errEmailTaken
+			}
+			if !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+			user.PendingEmail = newEmail
+		} else if params.Email != nil {
+			// Asking for the current address again drops a pending change.
+			user.PendingEmail = ""
+		}
+		if hashedPwd != "" {
+			user.Password = hashedPwd
+		}
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+	if errors.Is(err, errEmailTaken) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update user.")
+		return
+	}
+	cfg.logins.succeed(user.Email)
+
+	if newEmail != "" {
+		err = cfg.sendVerificationEmail(user, newEmail)
+		if err != nil {
+			log.Printf("Unable to send verification email to user %d: %s", user.ID, err)
+		}
+		cfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy email address is being changed",
+			Body: fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s. "+
+				"It changes once the new address is verified.\n\n"+
+				"If this wasn't you, reset your password right away.\n", newEmail),
+		})
+	}
+	if hashedPwd != "" {
+		cfg.sendMail(mail.Message{
+			To:      user.Email,
+			Subject: "Your Chirpy password was changed",
+			Body:    "The password of your Chirpy account was just changed. If this wasn't you, reset your password right away.\n",
+		})
+	}
+	respondWithJSON(w, 200, newUserResponse(user))
+}
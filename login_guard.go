@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// loginLimit is how many failed logins are tolerated and what happens
+// after that. The first freeAttempts failures cost nothing; each one after
+// that doubles the wait before the next attempt, starting at a second and
+// capped at maxBackoff. lockoutAfter failures lock the subject out for
+// lockout. Failures are forgotten forgetAfter the last one.
+type loginLimit struct {
+	freeAttempts int
+	lockoutAfter int
+	maxBackoff   time.Duration
+	lockout      time.Duration
+	forgetAfter  time.Duration
+}
+
+// An IP address gets more room than an account because many users can
+// share one behind a NAT.
+var (
+	accountLoginLimit = loginLimit{
+		freeAttempts: 3,
+		lockoutAfter: 10,
+		maxBackoff:   5 * time.Minute,
+		lockout:      30 * time.Minute,
+		forgetAfter:  time.Hour,
+	}
+	ipLoginLimit = loginLimit{
+		freeAttempts: 20,
+		lockoutAfter: 100,
+		maxBackoff:   5 * time.Minute,
+		lockout:      time.Hour,
+		forgetAfter:  time.Hour,
+	}
+)
+
+const (
+	loginSubjectAccount = "account"
+	loginSubjectIP      = "ip"
+)
+
+// maxLoginEntries bounds how many subjects are tracked before stale ones
+// are swept out.
+const maxLoginEntries = 100000
+
+type loginKey struct {
+	kind    string
+	subject string
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// loginGuard tracks failed logins per account and per IP address. Accounts
+// are tracked by the email that was tried whether or not a user has it, so
+// a lockout says nothing about which emails are registered. State is kept
+// in memory and starts over when the server restarts.
+type loginGuard struct {
+	mux      *sync.Mutex
+	attempts map[loginKey]*loginAttempts
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{
+		mux:      &sync.Mutex{},
+		attempts: map[loginKey]*loginAttempts{},
+	}
+}
+
+func limitFor(kind string) loginLimit {
+	if kind == loginSubjectIP {
+		return ipLoginLimit
+	}
+	return accountLoginLimit
+}
+
+// get returns the live attempts for key, dropping them first if they have
+// expired. The caller holds g.mux.
+func (g *loginGuard) get(key loginKey, now time.Time) *loginAttempts {
+	a, ok := g.attempts[key]
+	if !ok {
+		return nil
+	}
+	limit := limitFor(key.kind)
+	if a.locked && !now.Before(a.blockedUntil) {
+		delete(g.attempts, key)
+		return nil
+	}
+	if !a.locked && now.Sub(a.lastFailure) > limit.forgetAfter {
+		delete(g.attempts, key)
+		return nil
+	}
+	return a
+}
+
+func loginKeys(email string, ip string) []loginKey {
+	return []loginKey{{loginSubjectAccount, normalizeEmail(email)}, {loginSubjectIP, ip}}
+}
+
+// block sets how long a is blocked after its latest failure, going by how
+// many failures it has.
+func (a *loginAttempts) block(limit loginLimit) {
+	a.locked = a.failures >= limit.lockoutAfter
+	switch {
+	case a.locked:
+		a.blockedUntil = a.lastFailure.Add(limit.lockout)
+	case a.failures > limit.freeAttempts:
+		backoff := limit.maxBackoff
+		if shift := a.failures - limit.freeAttempts - 1; shift < 30 {
+			backoff = min(time.Second<<shift, limit.maxBackoff)
+		}
+		a.blockedUntil = a.lastFailure.Add(backoff)
+	default:
+		a.blockedUntil = time.Time{}
+	}
+}
+
+// begin starts a login for email from ip. If it has to wait, begin says
+// for how long and the login must not go ahead. Otherwise the login is
+// counted as failed straight away, under the same lock as the check, so
+// parallel guesses can't all get past the check before any of them is
+// counted. done must be called once with the outcome: done(true) takes the
+// failure back, done(false) keeps it.
+func (g *loginGuard) begin(email string, ip string) (time.Duration, func(ok bool)) {
+	keys := loginKeys(email, ip)
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		a := g.get(key, now)
+		if a != nil && a.blockedUntil.After(now) {
+			wait = max(wait, a.blockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait, func(bool) {}
+	}
+
+	if len(g.attempts) >= maxLoginEntries {
+		g.sweep(now)
+	}
+	for _, key := range keys {
+		a := g.get(key, now)
+		if a == nil {
+			a = &loginAttempts{}
+			g.attempts[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+		a.block(limitFor(key.kind))
+	}
+	return 0, func(ok bool) {
+		if ok {
+			g.refund(keys)
+		}
+	}
+}
+
+// refund takes back a failure begin counted for keys. Subjects cleared in
+// the meantime are left alone.
+func (g *loginGuard) refund(keys []loginKey) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		a := g.get(key, now)
+		if a == nil {
+			continue
+		}
+		a.failures--
+		if a.failures <= 0 {
+			delete(g.attempts, key)
+			continue
+		}
+		a.block(limitFor(key.kind))
+	}
+}
+
+// succeed forgets the failed logins for email. Those from the IP address
+// are kept, or an attacker could clear them with an account of their own.
+func (g *loginGuard) succeed(email string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	delete(g.attempts, loginKey{loginSubjectAccount, normalizeEmail(email)})
+}
+
+// sweep drops every expired entry. The caller holds g.mux.
+func (g *loginGuard) sweep(now time.Time) {
+	for key := range g.attempts {
+		g.get(key, now)
+	}
+}
+
+// LoginLockout is a subject with recent failed logins, as shown to admins.
+type LoginLockout struct {
+	Kind         string     `json:"kind"`
+	Subject      string     `json:"subject"`
+	Failures     int        `json:"failures"`
+	LastFailure  time.Time  `json:"last_failure"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+	Locked       bool       `json:"locked"`
+}
+
+func (g *loginGuard) list() []LoginLockout {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	now := time.Now()
+	g.sweep(now)
+	lockouts := []LoginLockout{}
+	for key, a := range g.attempts {
+		l := LoginLockout{
+			Kind:        key.kind,
+			Subject:     key.subject,
+			Failures:    a.failures,
+			LastFailure: a.lastFailure.UTC(),
+			Locked:      a.locked,
+		}
+		if a.blockedUntil.After(now) {
+			until := a.blockedUntil.UTC()
+			l.BlockedUntil = &until
+		}
+		lockouts = append(lockouts, l)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts
+}
+
+// clear forgets the failed logins of one subject and reports whether there
+// were any.
+func (g *loginGuard) clear(kind string, subject string) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if kind == loginSubjectAccount {
+		subject = normalizeEmail(subject)
+	}
+	key := loginKey{kind, subject}
+	_, ok := g.attempts[key]
+	delete(g.attempts, key)
+	return ok
+}
+
+func respondLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(wait.Round(time.Second)/time.Second)+1))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+}
+
+func (cfg *apiConfig) handlerGetLockouts(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, cfg.logins.list())
+}
+
+func (cfg *apiConfig) handlerDeleteLockout(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if kind != loginSubjectAccount && kind != loginSubjectIP {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown lockout kind %q", kind))
+		return
+	}
+	if !cfg.logins.clear(kind, r.PathValue("subject")) {
+		respondWithError(w, http.StatusNotFound, "No failed logins recorded")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
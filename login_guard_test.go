@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLoginGuardCountsParallelGuesses(t *testing.T) {
+	g := newLoginGuard()
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	admitted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, done := g.begin("a@example.com", "192.0.2.1")
+			if wait > 0 {
+				return
+			}
+			mux.Lock()
+			admitted++
+			mux.Unlock()
+			done(false)
+		}()
+	}
+	wg.Wait()
+	// The free attempts plus the one whose failure starts the backoff.
+	if want := accountLoginLimit.freeAttempts + 1; admitted != want {
+		t.Errorf("%d parallel guesses got through, want %d", admitted, want)
+	}
+}
+
+func TestLoginGuardRefundsSuccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []bool
+		// want is how many failures the account is left with.
+		want int
+	}{
+		{"success", []bool{true}, 0},
+		{"failure", []bool{false}, 1},
+		{"mixed", []bool{false, true, false, true, true}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newLoginGuard()
+			for _, ok := range tt.outcomes {
+				wait, done := g.begin("a@example.com", "192.0.2.1")
+				if wait > 0 {
+					t.Fatalf("login throttled after %v", tt.outcomes)
+				}
+				done(ok)
+			}
+			got := 0
+			if a := g.attempts[loginKey{loginSubjectAccount, "a@example.com"}]; a != nil {
+				got = a.failures
+			}
+			if got != tt.want {
+				t.Errorf("account has %d failures, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer is for local development. It writes each message to an .eml
+// file in a directory, or to the log when there is no directory.
+type FileMailer struct {
+	dir   string
+	count atomic.Int64
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if m.dir == "" {
+		log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), m.count.Add(1))
+	return os.WriteFile(filepath.Join(m.dir, name), msg.bytes(), 0600)
+}
@@ -1,9 +1,6 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,20 +12,45 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bigbabyjack/chirpy/auth"
 	"github.com/bigbabyjack/chirpy/database"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/bigbabyjack/chirpy/mail"
+	"github.com/bigbabyjack/chirpy/oidc"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	db             *database.DB
-	jwtSecret      string
-	polkaApiKey    string
+	db             database.Store
+	keys           *auth.Keyring
+	passwords      auth.PasswordHasher
+	passwordPolicy auth.PasswordPolicy
+	logins         *loginGuard
+	mailer         mail.Mailer
+	mailFrom       string
+	// appURL is where links in emails point.
+	appURL string
+	// apiURL is where this server is reached from outside, for callbacks.
+	apiURL        string
+	oidcProviders map[string]*oidc.Provider
+	// deletionGrace is how long deleted accounts are kept before they are
+	// purged, and chirpDeletion what happens to their chirps then.
+	deletionGrace time.Duration
+	chirpDeletion string
+	// chirpEditWindow is how long after posting a chirp can be edited.
+	chirpEditWindow time.Duration
+	// dummyHash is checked against when a login names an unknown email, so
+	// it takes as long as one with a wrong password.
+	dummyHash   string
+	polkaApiKey string
 }
 
+// errForbidden is returned from inside a transaction when the caller may
+// not touch the record, so the handler can roll back and answer 403.
+var errForbidden = errors.New("Unauthorized.")
+
 const dbPath string = "database.json"
+const sqlitePath string = "chirpy.db"
 const port string = "8080"
 const filepathRoot string = "/"
 
@@ -37,34 +59,96 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error loading .env file")
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatalf("JWT_SECRET not found in .env file")
+	keys, err := keyringFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to load JWT keys: %s", err)
 	}
-	polkaAPIKey := os.Getenv("POLKA_API_KEY")
-	if jwtSecret == "" {
-		log.Fatalf("JWT_SECRET not found in .env file")
+	argon2Params, err := argon2ParamsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password hashing configuration: %s", err)
+	}
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password policy: %s", err)
 	}
+	polkaAPIKey := os.Getenv("POLKA_API_KEY")
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	storeBackend := flag.String("store", "json", "Storage backend to use: json, sqlite or memory")
+	adminEmail := flag.String("promote-admin", "", "Make the user with this email an admin and exit")
 	flag.Parse()
+	mailer, err := mailerFromEnv(*dbg)
+	if err != nil {
+		log.Fatalf("Invalid mail configuration: %s", err)
+	}
+	apiURL := strings.TrimSuffix(envOr("API_URL", "http://localhost:"+port), "/")
+	oidcProviders, err := oidcProvidersFromEnv(apiURL)
+	if err != nil {
+		log.Fatalf("Invalid OIDC configuration: %s", err)
+	}
+	deletionGrace, chirpDeletion, err := accountDeletionFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid account deletion configuration: %s", err)
+	}
+	chirpEditWindow, err := durationFromEnv("CHIRP_EDIT_WINDOW", defaultChirpEditWindow)
+	if err != nil {
+		log.Fatalf("Invalid chirp configuration: %s", err)
+	}
 	if *dbg {
 		err := os.Remove(dbPath)
 		if err != nil {
 			log.Fatalf("Unable to delete database in debug mode: %s", err.Error())
 			return
 		}
+		err = os.Remove(dbPath + ".wal")
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Unable to delete database journal in debug mode: %s", err.Error())
+			return
+		}
 	}
 
-	db, err := database.NewDB(dbPath)
+	ids, err := idGeneratorFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid ID configuration: %s", err)
+	}
+	db, err := openStore(*storeBackend, ids)
 	if err != nil {
 		log.Fatalf("Error starting database: %s", err)
 	}
+	if *adminEmail != "" {
+		err := promoteAdmin(db, *adminEmail)
+		if err != nil {
+			log.Fatalf("Unable to make %s an admin: %s", *adminEmail, err)
+		}
+		log.Printf("%s is now an admin", *adminEmail)
+		return
+	}
+	reloadKeysOnHangup(keys)
+
+	passwords := auth.NewArgon2idHasher(argon2Params)
+	dummyHash, err := passwords.Hash("not the password of anyone")
+	if err != nil {
+		log.Fatalf("Unable to hash password: %s", err)
+	}
+
 	cfg := &apiConfig{
-		fileserverHits: 0,
-		db:             db,
-		jwtSecret:      jwtSecret,
-		polkaApiKey:    polkaAPIKey,
+		fileserverHits:  0,
+		db:              db,
+		keys:            keys,
+		passwords:       passwords,
+		passwordPolicy:  passwordPolicy,
+		logins:          newLoginGuard(),
+		dummyHash:       dummyHash,
+		mailer:          mailer,
+		mailFrom:        envOr("MAIL_FROM", "Chirpy <no-reply@localhost>"),
+		appURL:          strings.TrimSuffix(envOr("APP_URL", "http://localhost:"+port+"/app"), "/"),
+		apiURL:          apiURL,
+		oidcProviders:   oidcProviders,
+		deletionGrace:   deletionGrace,
+		chirpDeletion:   chirpDeletion,
+		chirpEditWindow: chirpEditWindow,
+		polkaApiKey:     polkaAPIKey,
 	}
+	cfg.purgeDeletedAccountsEvery(purgeInterval)
 
 	mux := http.NewServeMux()
 	srv := &http.Server{
@@ -73,329 +157,144 @@ func main() {
 	}
 
 	mux.Handle("/app/", cfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
-	mux.HandleFunc("GET /admin/metrics", func(w http.ResponseWriter, r *http.Request) {
-		html := fmt.Sprintf(`<html>
-			<body>
-				<h1>Welcome, Chirpy Admin</h1>
-				<p>Chirpy has been visited %d times!</p>
-			</body>
-
-			</html>`,
-			cfg.fileserverHits)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(html))
-	})
-	mux.HandleFunc("/api/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/metrics", cfg.requireScope(ScopeAdminMetrics, cfg.handlerMetrics))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requireScope(ScopeUsersAdmin, cfg.handlerUpdateUserRole))
+	mux.HandleFunc("GET /admin/lockouts", cfg.requireScope(ScopeAdminLockouts, cfg.handlerGetLockouts))
+	mux.HandleFunc("DELETE /admin/lockouts/{kind}/{subject}", cfg.requireScope(ScopeAdminLockouts, cfg.handlerDeleteLockout))
+	mux.HandleFunc("/api/reset", cfg.requireScope(ScopeAdminReset, cfg.handlerReset))
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+
+	mux.HandleFunc("POST /api/chirps", cfg.requireScope(ScopeChirpsWrite, cfg.handlerCreateChirps))
+	mux.HandleFunc("GET /api/chirps", cfg.optionalAuth(cfg.handlerGetChirps))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.optionalAuth(cfg.handlerGetChirp))
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", cfg.requireScope(ScopeChirpsWrite, cfg.handlerUpdateChirp))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", cfg.optionalAuth(cfg.handlerGetChirpHistory))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.optionalAuth(cfg.handlerGetChirpThread))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.requireScope(ScopeChirpsWrite, cfg.handlerDeleteChirp))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/like", cfg.requireScope(ScopeChirpsWrite, cfg.handlerLikeChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", cfg.requireScope(ScopeChirpsWrite, cfg.handlerUnlikeChirp))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/rechirp", cfg.requireScope(ScopeChirpsWrite, cfg.handlerRechirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", cfg.requireScope(ScopeChirpsWrite, cfg.handlerUndoRechirp))
+	mux.HandleFunc("GET /api/users/{userID}/likes", cfg.optionalAuth(cfg.handlerGetUserLikes))
+
+	mux.HandleFunc("POST /api/users", cfg.handlerCreateUser)
+	mux.HandleFunc("PUT /api/users", cfg.requireFirstParty(cfg.handlerUpdateUser))
+	mux.HandleFunc("GET /api/account/export", cfg.requireFirstParty(cfg.handlerExportAccount))
+	mux.HandleFunc("DELETE /api/account", cfg.requireFirstParty(cfg.handlerDeleteAccount))
+	mux.HandleFunc("POST /api/account/restore", cfg.requireFirstParty(cfg.handlerRestoreAccount))
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/email/verify/request", cfg.requireAuth(cfg.handlerRequestEmailVerification))
+	mux.HandleFunc("POST /api/email/verify", cfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/password/reset/request", cfg.handlerRequestPasswordReset)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerResetPassword)
+	mux.HandleFunc("POST /api/login/mfa", cfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/mfa/totp", cfg.requireFirstParty(cfg.handlerEnrollTOTP))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.requireFirstParty(cfg.handlerConfirmTOTP))
+	mux.HandleFunc("DELETE /api/mfa/totp", cfg.requireFirstParty(cfg.handlerDisableTOTP))
+	mux.HandleFunc("POST /api/mfa/recovery-codes", cfg.requireFirstParty(cfg.handlerRegenerateRecoveryCodes))
+
+	mux.HandleFunc("POST /api/keys", cfg.requireFirstParty(cfg.handlerCreateAPIKey))
+	mux.HandleFunc("GET /api/keys", cfg.requireFirstParty(cfg.handlerGetAPIKeys))
+	mux.HandleFunc("PATCH /api/keys/{keyID}", cfg.requireFirstParty(cfg.handlerUpdateAPIKey))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.requireAuth(cfg.handlerDeleteAPIKey))
+
+	mux.HandleFunc("POST /api/oauth/clients", cfg.requireFirstParty(cfg.handlerCreateOAuthClient))
+	mux.HandleFunc("GET /api/oauth/clients", cfg.requireFirstParty(cfg.handlerGetOAuthClients))
+	mux.HandleFunc("GET /oauth/authorize", cfg.handlerAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", cfg.handlerAuthorizeDecision)
+	mux.HandleFunc("POST /oauth/token", cfg.handlerOAuthToken)
+
+	mux.HandleFunc("GET /api/oidc/providers", cfg.handlerGetOIDCProviders)
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.handlerOIDCCallback)
+	mux.HandleFunc("GET /api/identities", cfg.requireFirstParty(cfg.handlerGetIdentities))
+	mux.HandleFunc("DELETE /api/identities/{identityID}", cfg.requireFirstParty(cfg.handlerDeleteIdentity))
+
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.requireFirstParty(cfg.handlerGetSessions))
+	mux.HandleFunc("DELETE /api/sessions", cfg.requireFirstParty(cfg.handlerDeleteSessions))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.requireFirstParty(cfg.handlerDeleteSession))
+
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerPolkaWebhook)
 
-	mux.HandleFunc("POST /api/chirps", cfg.handlerCreateChirps)
-	mux.HandleFunc("GET /api/chirps", cfg.handlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerGetChirp)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Hello from the delete endpoint")
-		tokenString, err := getBearerTokenFromHeader(r)
-		if err != nil {
-			respondWithError(w, 403, "Cannot parse JWT token")
-			return
-		}
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		})
-		if err != nil {
-			respondWithError(w, 403, "Cannot parse JWT token")
-			return
-		}
+	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
+	log.Fatal(srv.ListenAndServe())
 
-		claims := token.Claims.(*jwt.RegisteredClaims)
-		authorID, err := strconv.Atoi(claims.Subject)
-		if err != nil {
-			respondWithError(w, 403, "Unauthorized.")
-			return
-		}
+}
 
-		chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+func openStore(backend string, ids database.IDGenerator) (database.Store, error) {
+	switch backend {
+	case "json":
+		db, err := database.NewDB(dbPath)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirpID %v", chirpID))
-			return
+			return nil, err
 		}
-
-		chirp, err := cfg.db.GetChirp(chirpID)
+		db.SetIDGenerator(ids)
+		return db, nil
+	case "sqlite":
+		db, err := database.NewSQLiteStore(sqlitePath)
 		if err != nil {
-			respondWithError(w, 404, fmt.Sprintf("Chirp with ID %v not found", chirpID))
-			return
-		}
-		if authorID == chirp.AuthorID {
-			err := cfg.db.DeleteChirp(chirp.ID)
+			return nil, err
+		}
+		db.SetIDGenerator(ids)
+		return db, nil
+	case "memory":
+		db := database.NewMemoryStore()
+		db.SetIDGenerator(ids)
+		return db, nil
+	}
+	return nil, fmt.Errorf("Unknown store backend: %s", backend)
+}
+
+// idGeneratorFromEnv picks the ID scheme from ID_STRATEGY ("sequence", the
+// default, or "snowflake" with an optional SNOWFLAKE_NODE).
+//
+// Snowflake IDs are above 2^53 and are sent as JSON numbers, which
+// JavaScript and other clients that parse numbers as doubles silently
+// round. They are refused unless SNOWFLAKE_CLIENTS_READY is "true", to say
+// every client reads IDs as 64-bit integers.
+func idGeneratorFromEnv() (database.IDGenerator, error) {
+	switch os.Getenv("ID_STRATEGY") {
+	case "", "sequence":
+		return database.SequenceIDs{}, nil
+	case "snowflake":
+		if os.Getenv("SNOWFLAKE_CLIENTS_READY") != "true" {
+			return nil, fmt.Errorf("Snowflake IDs do not fit in a double; set SNOWFLAKE_CLIENTS_READY=true once every client reads IDs as 64-bit integers")
+		}
+		node := 0
+		if s := os.Getenv("SNOWFLAKE_NODE"); s != "" {
+			n, err := strconv.Atoi(s)
 			if err != nil {
-				respondWithError(w, 500, err.Error())
-				return
+				return nil, fmt.Errorf("SNOWFLAKE_NODE must be a number")
 			}
-			fmt.Println("Deleted")
-			respondWithJSON(w, 204, struct{}{})
-			return
-		}
-		respondWithError(w, 403, "Unauthorized.")
-		return
-	})
-
-	mux.HandleFunc("POST /api/users", func(w http.ResponseWriter, r *http.Request) {
-		type parameters struct {
-			Email    string `json:"email"`
-			Password string `json:"password"`
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
-		err := decoder.Decode(&params)
-		if err != nil {
-			respondWithError(w, 500, "Error decoding parameters.")
-			return
-		}
-		err = verifyPasswordCreation(params.Password)
-		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
-		if err != nil {
-			respondWithError(w, 500, "Password must be between 5 and 12 characters.")
-		}
-		user, err := cfg.db.CreateUser(params.Email, string(hashedPwd))
-		if err != nil {
-			respondWithError(w, 500, "Error creating user.")
-		}
-		respondWithJSON(w, 201, UserResponse{
-			user.ID,
-			user.Email,
-			user.IsChirpyRed,
-		})
-	})
-
-	mux.HandleFunc("PUT /api/users", func(w http.ResponseWriter, r *http.Request) {
-		tokenString, err := getBearerTokenFromHeader(r)
-		if err != nil {
-			respondWithError(w, 401, "Cannot parse JWT token")
-			return
-		}
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		})
-		if err != nil {
-			respondWithError(w, 401, "Cannot parse JWT token")
-			return
-		}
-
-		claims := token.Claims.(*jwt.RegisteredClaims)
-		id := claims.Subject
-
-		params := database.User{}
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&params)
-		if err != nil {
-			respondWithError(w, 500, err.Error())
-			return
-		}
-
-		ID, err := strconv.Atoi(id)
-		if err != nil {
-			respondWithError(w, 500, err.Error())
-			return
-		}
-		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
-		params.Password = string(hashedPwd)
-		if err != nil {
-			respondWithError(w, 500, "Password must be between 5 and 12 characters.")
-			return
+			node = n
 		}
-
-		user, err := cfg.db.UpdateUser(ID, params)
-		if err != nil {
-			respondWithError(w, 500, err.Error())
-			return
-		}
-		respondWithJSON(w, 200, UserResponse{
-			user.ID,
-			params.Email,
-			user.IsChirpyRed,
-		})
-
-	})
-
-	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
-		type parameters struct {
-			Email            string `json:"email"`
-			Password         string `json:"password"`
-			ExpiresInSeconds *int64 `json:"expires_in_seconds"`
-		}
-		decoder := json.NewDecoder(r.Body)
-		params := parameters{}
-		err := decoder.Decode(&params)
-		if err != nil {
-			respondWithError(w, 500, "Unable to parse email and password")
-			return
-		}
-		user, err := db.GetUser(params.Email)
-		if err != nil {
-			respondWithError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		// compare password hash
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password))
-		if err != nil {
-			respondWithError(w, 401, "Invalid username and password combination.")
-			return
-		}
-
-		var expiresInSeconds int64
-		if params.ExpiresInSeconds != nil {
-			params.ExpiresInSeconds = &expiresInSeconds
-		} else {
-			expiresInSeconds = int64(1 * time.Hour.Seconds())
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Duration(expiresInSeconds) * time.Second)),
-			Subject:   strconv.Itoa(user.ID),
-		})
-
-		signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
-		if err != nil {
-			log.Println(err.Error())
-			respondWithError(w, 500, "Unable to get JWT Token")
-			return
-		}
-		b := make([]byte, 32)
-		_, err = rand.Read(b)
-		if err != nil {
-			respondWithError(w, 500, "Internal Error")
-			return
-		}
-		refreshToken := hex.EncodeToString(b)
-		err = cfg.db.UpdateRefreshToken(user.ID, refreshToken)
-		if err != nil {
-			respondWithError(w, 500, "Internal Error")
-			return
-		}
-
-		respondWithJSON(w, 200, UserResponseWithToken{
-			user.ID,
-			user.Email,
-			signedToken,
-			refreshToken,
-			user.IsChirpyRed,
-		})
-	})
-
-	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
-		refreshToken, err := getBearerTokenFromHeader(r)
-		if err != nil {
-			respondWithError(w, 401, err.Error())
-		}
-		u, err := cfg.db.VerifyRefreshToken(refreshToken)
-		if err != nil {
-			respondWithError(w, 401, "Unauthorized user.")
-			return
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Duration(time.Hour.Seconds()) * time.Second)),
-			Subject:   strconv.Itoa(u.ID),
-		})
-
-		signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
-		respondWithJSON(w, 200, struct {
-			Token string `json:"token"`
-		}{signedToken})
-	})
-
-	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
-		refreshToken, err := getBearerTokenFromHeader(r)
-		if err != nil {
-			respondWithError(w, 401, err.Error())
-			return
-		}
-		err = cfg.db.RevokeRefreshToken(refreshToken)
-		if err != nil {
-			respondWithError(w, 401, "Invalid token")
-			return
-		}
-		respondWithJSON(w, 204, struct{}{})
-	})
-
-	mux.HandleFunc("POST /api/polka/webhooks", func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			respondWithError(w, 401, "Don't recognize api key")
-			return
-		}
-
-		polkaAPIKey := strings.TrimPrefix(authHeader, "ApiKey ")
-		if cfg.polkaApiKey != polkaAPIKey {
-			respondWithError(w, 401, "Don't recognize api key")
-			return
-		}
-		type polkaHook struct {
-			Event string         `json:"event"`
-			Data  map[string]int `json:"data"`
-		}
-
-		request := &polkaHook{}
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(request)
-		if err != nil {
-			respondWithError(w, 404, err.Error())
-			return
-		}
-		if request.Event != "user.upgraded" {
-			respondWithError(w, 204, "")
-			return
-		}
-		userID, ok := request.Data["user_id"]
-		if !ok {
-			respondWithError(w, 404, "")
-			return
-		}
-		user, err := cfg.db.GetUserByID(userID)
-		if err != nil {
-			respondWithError(w, 404, err.Error())
-			return
-		}
-		user.IsChirpyRed = true
-		_, err = cfg.db.UpdateUser(userID, user)
-		if err != nil {
-			respondWithError(w, 500, err.Error())
-			return
-		}
-		respondWithJSON(w, 204, fmt.Sprintf("User %d upgraded.", userID))
-
-	})
-
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
-
-}
-
-type UserResponseWithToken struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	JWTToken     string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+		return database.NewSnowflakeIDs(node)
+	}
+	return nil, fmt.Errorf("Unknown ID_STRATEGY: %s", os.Getenv("ID_STRATEGY"))
 }
 
-type UserResponse struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
 }
 
-func verifyPasswordCreation(p string) error {
-	if len(p) > 12 || len(p) < 5 {
-		return fmt.Errorf("Password must be between 5 and 12 characters")
+// durationFromEnv reads the environment variable name as a duration such
+// as 15m or 720h, falling back to fallback when it is unset.
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback, nil
 	}
-
-	return nil
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration such as 15m", name)
+	}
+	return d, nil
 }
 
 func getCleanedBody(profaneWords []string, body string) string {
@@ -416,6 +315,9 @@ func getBearerTokenFromHeader(r *http.Request) (string, error) {
 		return "", errors.New("Unable to parse bearer token")
 	}
 
-	refreshToken := strings.TrimPrefix(authHeader, "Bearer ")
-	return refreshToken, nil
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || token == "" {
+		return "", errors.New("Authorization header is not a bearer token")
+	}
+	return token, nil
 }
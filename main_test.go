@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// newTestConfig returns a config backed by an empty memory store.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	// Cheap parameters keep the tests fast.
+	passwords := auth.NewArgon2idHasher(auth.Argon2idParams{
+		Memory:     64,
+		Time:       1,
+		Threads:    1,
+		SaltLength: 16,
+		KeyLength:  32,
+	})
+	dummyHash, err := passwords.Hash("not the password of anyone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		db:             database.NewMemoryStore(),
+		keys:           auth.NewHMACKeyring("secret"),
+		passwords:      passwords,
+		passwordPolicy: auth.DefaultPasswordPolicy,
+		logins:         newLoginGuard(),
+		dummyHash:      dummyHash,
+	}
+}
+
+// createTestUser stores a user with password hashed the way cfg hashes.
+func createTestUser(t *testing.T, cfg *apiConfig, email string, password string) database.User {
+	t.Helper()
+	hash, err := cfg.passwords.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storeTestUser(t, cfg, email, hash)
+}
+
+// storeTestUser stores a user with the given password hash as is.
+func storeTestUser(t *testing.T, cfg *apiConfig, email string, hash string) database.User {
+	t.Helper()
+	var user database.User
+	err := cfg.db.Update(func(tx database.Tx) error {
+		var err error
+		user, err = tx.CreateUser(email, hash)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// asUser returns r as if requireAuth had let it through for user.
+func asUser(r *http.Request, user database.User) *http.Request {
+	return r.WithContext(withPrincipal(r.Context(), Principal{
+		UserID: user.ID,
+		Role:   roleOf(user),
+		Scopes: scopesFor(user),
+	}))
+}
+
+// decodeResponse decodes the JSON body of w into v.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	err := json.NewDecoder(w.Body).Decode(v)
+	if err != nil {
+		t.Fatalf("Unable to decode %d response %q: %s", w.Code, w.Body.String(), err)
+	}
+}
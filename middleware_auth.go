@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	jwtIssuer      = "chirpy"
+	jwtAudience    = "chirpy-api"
+	accessTokenTTL = time.Hour
+)
+
+// accessClaims are the claims of an access token. Scope is space separated,
+// as in OAuth. ClientID is set on tokens issued to a third-party app.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Role     string `json:"role,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Principal is the authenticated caller of a request. Claims is set for
+// callers with an access token, APIKeyID for callers with an API key, and
+// ClientID for third-party apps acting for the user.
+type Principal struct {
+	UserID   int
+	Role     string
+	Scopes   []string
+	Claims   *accessClaims
+	APIKeyID int
+	ClientID string
+}
+
+// FirstParty reports whether the user is calling themselves, through a
+// login of their own, rather than through an API key or a third-party app.
+func (p Principal) FirstParty() bool {
+	return p.APIKeyID == 0 && p.ClientID == ""
+}
+
+type contextKey int
+
+const principalContextKey contextKey = iota
+
+func withPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// principalFromContext returns the caller put there by requireAuth or
+// optionalAuth. ok is false for anonymous requests.
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(Principal)
+	return p, ok
+}
+
+// issueAccessToken signs a short-lived access token for user carrying their
+// current role and scopes.
+func (cfg *apiConfig) issueAccessToken(user database.User, ttl time.Duration) (string, error) {
+	return cfg.signAccessToken(user, scopesFor(user), "", ttl)
+}
+
+// signAccessToken signs an access token for user with the given scopes,
+// on behalf of the OAuth client clientID if it is set.
+func (cfg *apiConfig) signAccessToken(user database.User, scopes []string, clientID string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	return cfg.keys.Sign(accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			Subject:   strconv.Itoa(user.ID),
+		},
+		Role:     roleOf(user),
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+	})
+}
+
+// parseAccessToken checks the signature and algorithm of an access token
+// and that it was issued by and for this API and has not expired.
+func (cfg *apiConfig) parseAccessToken(tokenString string) (Principal, error) {
+	token, err := cfg.keys.Parse(tokenString, &accessClaims{})
+	if err != nil {
+		return Principal{}, err
+	}
+	claims := token.Claims.(*accessClaims)
+	if claims.ExpiresAt == nil {
+		return Principal{}, errors.New("Token has no expiry")
+	}
+	if !claims.VerifyIssuer(jwtIssuer, true) {
+		return Principal{}, fmt.Errorf("Token issuer %q is not accepted", claims.Issuer)
+	}
+	if !claims.VerifyAudience(jwtAudience, true) {
+		return Principal{}, errors.New("Token is not meant for this API")
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Principal{}, fmt.Errorf("Invalid token subject %q", claims.Subject)
+	}
+	return Principal{
+		UserID:   userID,
+		Role:     claims.Role,
+		Scopes:   strings.Fields(claims.Scope),
+		Claims:   claims,
+		ClientID: claims.ClientID,
+	}, nil
+}
+
+// authenticate returns the caller of r, who may present an access token
+// ("Authorization: Bearer ...") or an API key ("Authorization: ApiKey ...").
+// ok is false when r carries no credentials at all; err is set when it
+// carries bad ones.
+func (cfg *apiConfig) authenticate(r *http.Request) (p Principal, ok bool, err error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Principal{}, false, nil
+	}
+	if key, isKey := strings.CutPrefix(header, "ApiKey "); isKey {
+		p, err = cfg.authenticateAPIKey(r, key)
+		if err != nil {
+			return Principal{}, false, err
+		}
+		return p, true, nil
+	}
+	tokenString, err := getBearerTokenFromHeader(r)
+	if err != nil {
+		return Principal{}, false, err
+	}
+	p, err = cfg.parseAccessToken(tokenString)
+	if err != nil {
+		return Principal{}, false, err
+	}
+	return p, true, nil
+}
+
+func respondUnauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", ApiKey realm="chirpy"`)
+	respondWithError(w, http.StatusUnauthorized, msg)
+}
+
+// requireAuth only lets requests with a valid access token or API key
+// through to next.
+func (cfg *apiConfig) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := cfg.authenticate(r)
+		if err != nil {
+			respondUnauthorized(w, fmt.Sprintf("Invalid credentials: %s", err))
+			return
+		}
+		if !ok {
+			respondUnauthorized(w, "Authentication required")
+			return
+		}
+		next(w, r.WithContext(withPrincipal(r.Context(), p)))
+	}
+}
+
+// requireFirstParty is requireAuth for managing the account itself, which
+// API keys and third-party apps may not do.
+func (cfg *apiConfig) requireFirstParty(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFromContext(r.Context())
+		if !p.FirstParty() {
+			respondWithError(w, http.StatusForbidden, "This needs a signed-in user, not an API key or app.")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// optionalAuth lets anonymous requests through to next but still rejects a
+// request whose credentials are present and invalid.
+func (cfg *apiConfig) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok, err := cfg.authenticate(r)
+		if err != nil {
+			respondUnauthorized(w, fmt.Sprintf("Invalid credentials: %s", err))
+			return
+		}
+		if ok {
+			r = r.WithContext(withPrincipal(r.Context(), p))
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TestTokenAudiences checks that only access tokens issued by and for this
+// API get through requireAuth, even when they are signed with its keys.
+func TestTokenAudiences(t *testing.T) {
+	cfg := newTestConfig(t)
+	now := time.Now().UTC()
+	valid := jwt.RegisteredClaims{
+		Issuer:    jwtIssuer,
+		Audience:  jwt.ClaimStrings{jwtAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		Subject:   "7",
+	}
+	tests := []struct {
+		name   string
+		keys   *auth.Keyring
+		claims func(c *jwt.RegisteredClaims)
+		want   int
+	}{
+		{"valid", cfg.keys, func(c *jwt.RegisteredClaims) {}, http.StatusOK},
+		{"other issuer", cfg.keys, func(c *jwt.RegisteredClaims) { c.Issuer = "elsewhere" }, http.StatusUnauthorized},
+		{"other audience", cfg.keys, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"chirpy-other"} }, http.StatusUnauthorized},
+		{"no audience", cfg.keys, func(c *jwt.RegisteredClaims) { c.Audience = nil }, http.StatusUnauthorized},
+		{"no expiry", cfg.keys, func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, http.StatusUnauthorized},
+		{"expired", cfg.keys, func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }, http.StatusUnauthorized},
+		{"bad subject", cfg.keys, func(c *jwt.RegisteredClaims) { c.Subject = "me" }, http.StatusUnauthorized},
+		{"other keys", auth.NewHMACKeyring("other"), func(c *jwt.RegisteredClaims) {}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid
+			tt.claims(&claims)
+			token, err := tt.keys.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+				p, ok := principalFromContext(r.Context())
+				if !ok || p.UserID != 7 {
+					t.Errorf("handler got principal %+v", p)
+				}
+			})(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+
+	// Every kind of token is signed with the same keys, so each must only
+	// be accepted where it is meant to be.
+	user := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+	accessToken, err := cfg.issueAccessToken(user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	cfg.respondMFAChallenge(w, user, time.Hour)
+	var challenge struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	decodeResponse(t, w, &challenge)
+
+	emailToken := func(audience string) string {
+		token, err := cfg.issueEmailToken(audience, emailTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.Itoa(user.ID)},
+			Email:            user.Email,
+		}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tokens := map[string]string{
+		"access":         accessToken,
+		"verify email":   emailToken(verifyEmailAudience),
+		"reset password": emailToken(resetPasswordAudience),
+		"mfa":            challenge.MFAToken,
+	}
+	parsers := map[string]func(string) error{
+		"access": func(s string) error {
+			_, err := cfg.parseAccessToken(s)
+			return err
+		},
+		"verify email": func(s string) error {
+			_, _, err := cfg.parseEmailToken(verifyEmailAudience, s)
+			return err
+		},
+		"reset password": func(s string) error {
+			_, _, err := cfg.parseEmailToken(resetPasswordAudience, s)
+			return err
+		},
+		"mfa": func(s string) error {
+			_, _, err := cfg.parseMFAToken(s)
+			return err
+		},
+	}
+	for tokenKind, token := range tokens {
+		for parserKind, parse := range parsers {
+			t.Run(tokenKind+" as "+parserKind, func(t *testing.T) {
+				err := parse(token)
+				if tokenKind == parserKind && err != nil {
+					t.Errorf("rejected: %s", err)
+				}
+				if tokenKind != parserKind && err == nil {
+					t.Error("accepted")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// Third-party apps get tokens through the OAuth 2 authorization code flow
+// (RFC 6749) with PKCE (RFC 7636), which is required of every client. The
+// user signs in and consents on a page served by /oauth/authorize; the app
+// then trades the code for an access token and a refresh token bound to
+// it at /oauth/token.
+const (
+	oauthCodeTTL       = time.Minute
+	maxOAuthClientName = 100
+	maxRedirectURIs    = 10
+)
+
+// scopeDescriptions is what the consent page says each scope allows.
+var scopeDescriptions = map[string]string{
+	ScopeChirpsWrite:    "Post and delete chirps as you",
+	ScopeChirpsModerate: "Delete anyone's chirps",
+	ScopeUsersAdmin:     "Change users' roles",
+	ScopeAdminMetrics:   "See server metrics",
+	ScopeAdminReset:     "Reset the server",
+	ScopeAdminLockouts:  "See and clear login lockouts",
+}
+
+var (
+	errInvalidClient = errors.New("Unknown client or wrong client credentials.")
+	errInvalidGrant  = errors.New("Invalid, expired or already used grant.")
+)
+
+// OAuthClientResponse is a registered app as shown to its owner.
+// ClientSecret is only set in the answer to the registration.
+type OAuthClientResponse struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func newOAuthClientResponse(c database.OAuthClient) OAuthClientResponse {
+	return OAuthClientResponse{
+		ClientID:     c.ClientID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIs,
+		Confidential: c.SecretHash != "",
+		CreatedAt:    time.Unix(c.CreatedAt, 0).UTC(),
+	}
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashOAuthSecret is how client secrets and authorization codes are stored
+// and looked up. Both are random, so a plain SHA-256 is enough.
+func hashOAuthSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// validRedirectURI accepts https URLs, http URLs on the loopback interface
+// for apps running on the user's machine, and private-use schemes such as
+// com.example.app:/callback for native apps (RFC 8252). Fragments are not
+// allowed, and the URI is later matched exactly.
+func validRedirectURI(raw string) bool {
+	if strings.ContainsAny(raw, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return strings.Contains(u.Scheme, ".")
+}
+
+// handlerCreateOAuthClient registers a third-party app owned by the
+// caller. Confidential clients, which run on a server, get a secret; it
+// is in the answer and can't be seen again.
+func (cfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	params := struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxOAuthClientName {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name must be 1 to %d characters", maxOAuthClientName))
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Give 1 to %d redirect URIs", maxRedirectURIs))
+		return
+	}
+	for _, u := range params.RedirectURIs {
+		if !validRedirectURI(u) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid redirect URI %q", u))
+			return
+		}
+	}
+
+	clientID, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to register client.")
+		return
+	}
+	c := database.OAuthClient{
+		ClientID:     clientID,
+		OwnerID:      principal.UserID,
+		Name:         params.Name,
+		RedirectURIs: params.RedirectURIs,
+		CreatedAt:    time.Now().UTC().Unix(),
+	}
+	var secret string
+	if params.Confidential {
+		secret, err = randomHex(32)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Unable to register client.")
+			return
+		}
+		c.SecretHash = hashOAuthSecret(secret)
+	}
+	err = cfg.db.Update(func(tx database.Tx) error {
+		c, err = tx.CreateOAuthClient(c)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to register client.")
+		return
+	}
+	resp := newOAuthClientResponse(c)
+	resp.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// handlerGetOAuthClients lists the apps the caller registered.
+func (cfg *apiConfig) handlerGetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	principal, _ := principalFromContext(r.Context())
+	var clients []database.OAuthClient
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		clients, err = tx.GetOAuthClientsByOwner(principal.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to retrieve clients.")
+		return
+	}
+	resp := make([]OAuthClientResponse, 0, len(clients))
+	for _, c := range clients {
+		resp = append(resp, newOAuthClientResponse(c))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// authorizeRequest is a checked request to /oauth/authorize. RedirectURI
+// is where to send the user back; GivenRedirectURI is the redirect_uri
+// the request named, which the token request has to repeat.
+type authorizeRequest struct {
+	Client           database.OAuthClient
+	RedirectURI      string
+	GivenRedirectURI string
+	Scopes           []string
+	State            string
+	CodeChallenge    string
+}
+
+// authorizeError is a problem with an authorization request. Once the
+// client and redirect URI are known to be good it is reported by
+// redirecting back to the client; before that it is shown to the user.
+type authorizeError struct {
+	Code        string
+	Description string
+	redirect    bool
+}
+
+func (e *authorizeError) Error() string {
+	return e.Description
+}
+
+// parseAuthorizeRequest checks the parameters of an authorization request,
+// which come in the query of the GET and the form of the POST.
+func (cfg *apiConfig) parseAuthorizeRequest(form url.Values) (authorizeRequest, error) {
+	req := authorizeRequest{State: form.Get("state")}
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		req.Client, err = tx.GetOAuthClient(form.Get("client_id"))
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return req, &authorizeError{"invalid_request", "Unknown client_id.", false}
+	}
+	if err != nil {
+		return req, err
+	}
+	req.GivenRedirectURI = form.Get("redirect_uri")
+	req.RedirectURI = req.GivenRedirectURI
+	if req.RedirectURI == "" && len(req.Client.RedirectURIs) == 1 {
+		req.RedirectURI = req.Client.RedirectURIs[0]
+	}
+	if !slices.Contains(req.Client.RedirectURIs, req.RedirectURI) {
+		return req, &authorizeError{"invalid_request", "redirect_uri is not registered for this client.", false}
+	}
+
+	if form.Get("response_type") != "code" {
+		return req, &authorizeError{"unsupported_response_type", "Only response_type=code is supported.", true}
+	}
+	req.CodeChallenge = form.Get("code_challenge")
+	if req.CodeChallenge == "" {
+		return req, &authorizeError{"invalid_request", "code_challenge is required.", true}
+	}
+	if form.Get("code_challenge_method") != "S256" {
+		return req, &authorizeError{"invalid_request", "code_challenge_method must be S256.", true}
+	}
+	if len(req.CodeChallenge) != base64.RawURLEncoding.EncodedLen(sha256.Size) {
+		return req, &authorizeError{"invalid_request", "code_challenge is not a SHA-256 hash.", true}
+	}
+	req.Scopes = []string{}
+	for _, s := range strings.Fields(form.Get("scope")) {
+		if !validScope(s) {
+			return req, &authorizeError{"invalid_scope", fmt.Sprintf("Unknown scope %q.", s), true}
+		}
+		if !slices.Contains(req.Scopes, s) {
+			req.Scopes = append(req.Scopes, s)
+		}
+	}
+	return req, nil
+}
+
+// redirectToClient sends the browser back to the client with params added
+// to its redirect URI.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// handlerAuthorize shows the sign-in and consent page for an app asking to
+// act on the user's behalf.
+func (cfg *apiConfig) handlerAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, err := cfg.parseAuthorizeRequest(r.URL.Query())
+	cfg.respondAuthorize(w, r, req, err, "", http.StatusOK)
+}
+
+// handlerAuthorizeDecision takes the consent form. Approving needs the
+// user's password, and their two-factor code if they have one enabled;
+// failures count against the login throttle like any other login.
+func (cfg *apiConfig) handlerAuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	req, err := cfg.parseAuthorizeRequest(r.PostForm)
+	if err != nil {
+		cfg.respondAuthorize(w, r, req, err, "", http.StatusOK)
+		return
+	}
+	if r.PostForm.Get("decision") != "approve" {
+		redirectToClient(w, r, req, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"The user denied the request."},
+		})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	wait, done := cfg.logins.begin(email, clientIP(r))
+	if wait > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Round(time.Second)/time.Second)+1))
+		cfg.respondAuthorize(w, r, req, nil, "Too many failed login attempts. Try again later.", http.StatusTooManyRequests)
+		return
+	}
+	user, err := cfg.checkPassword(email, r.PostForm.Get("password"))
+	if err == nil && user.TOTPEnabled {
+		err = cfg.db.Update(func(tx database.Tx) error {
+			user, err = tx.GetUserByID(user.ID)
+			if err != nil {
+				return err
+			}
+			if !useSecondFactor(&user, r.PostForm.Get("code")) {
+				return errInvalidMFACode
+			}
+			_, err = tx.UpdateUser(user.ID, user)
+			return err
+		})
+	}
+	badCredentials := errors.Is(err, errInvalidCredentials) || errors.Is(err, errInvalidMFACode)
+	done(!badCredentials)
+	if badCredentials {
+		cfg.respondAuthorize(w, r, req, nil, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		cfg.respondAuthorize(w, r, req, nil, "Unable to sign you in. Try again later.", http.StatusInternalServerError)
+		return
+	}
+	cfg.logins.succeed(user.Email)
+
+	code, err := randomHex(32)
+	if err != nil {
+		cfg.respondAuthorize(w, r, req, nil, "Unable to sign you in. Try again later.", http.StatusInternalServerError)
+		return
+	}
+	// The app gets the scopes it asked for that the user has.
+	scopes := grantedScopes(user, req.Scopes)
+	now := time.Now().UTC()
+	err = cfg.db.Update(func(tx database.Tx) error {
+		_, err := tx.CreateOAuthCode(database.OAuthCode{
+			CodeHash:      hashOAuthSecret(code),
+			ClientID:      req.Client.ClientID,
+			UserID:        user.ID,
+			RedirectURI:   req.GivenRedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: req.CodeChallenge,
+			CreatedAt:     now.Unix(),
+			ExpiresAt:     now.Add(oauthCodeTTL).Unix(),
+		})
+		return err
+	})
+	if err != nil {
+		cfg.respondAuthorize(w, r, req, nil, "Unable to sign you in. Try again later.", http.StatusInternalServerError)
+		return
+	}
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+type consentScope struct {
+	Name        string
+	Description string
+}
+
+type consentPage struct {
+	ClientName string
+	Scopes     []consentScope
+	Form       map[string]string
+	Email      string
+	Error      string
+	Fatal      bool
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+    {{if .Fatal}}
+    <h1>Unable to authorize</h1>
+    <p>{{.Error}}</p>
+    {{else}}
+    <h1>{{.ClientName}} wants to use your Chirpy account</h1>
+    {{if .Scopes}}
+    <p>It will be able to:</p>
+    <ul>
+        {{range .Scopes}}<li>{{.Description}} <code>{{.Name}}</code></li>
+        {{end}}
+    </ul>
+    {{else}}
+    <p>It will be able to see who you are.</p>
+    {{end}}
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="post" action="/oauth/authorize">
+        {{range $name, $value := .Form}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+        {{end}}
+        <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+        <p><label>Password <input type="password" name="password"></label></p>
+        <p><label>Two-factor code, if you use one <input type="text" name="code" autocomplete="one-time-code"></label></p>
+        <button type="submit" name="decision" value="approve">Allow</button>
+        <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+    {{end}}
+</body>
+</html>
+`))
+
+// respondAuthorize answers an authorization request: with the consent
+// page, with msg on it if set, or with reqErr. Errors the client should
+// hear about go back to it; the rest are shown to the user.
+func (cfg *apiConfig) respondAuthorize(w http.ResponseWriter, r *http.Request, req authorizeRequest, reqErr error, msg string, status int) {
+	var authErr *authorizeError
+	if errors.As(reqErr, &authErr) && authErr.redirect {
+		redirectToClient(w, r, req, url.Values{
+			"error":             {authErr.Code},
+			"error_description": {authErr.Description},
+		})
+		return
+	}
+	page := consentPage{
+		ClientName: req.Client.Name,
+		Email:      r.PostFormValue("email"),
+		Error:      msg,
+	}
+	switch {
+	case authErr != nil:
+		page.Fatal = true
+		page.Error = authErr.Description
+		status = http.StatusBadRequest
+	case reqErr != nil:
+		page.Fatal = true
+		page.Error = "Something went wrong. Try again later."
+		status = http.StatusInternalServerError
+	default:
+		for _, s := range req.Scopes {
+			page.Scopes = append(page.Scopes, consentScope{s, scopeDescriptions[s]})
+		}
+		page.Form = map[string]string{
+			"response_type":         "code",
+			"client_id":             req.Client.ClientID,
+			"redirect_uri":          req.GivenRedirectURI,
+			"scope":                 strings.Join(req.Scopes, " "),
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": "S256",
+		}
+	}
+	// The page takes a password, so keep it out of caches and frames.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	err := consentTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("Unable to render consent page: %s", err)
+	}
+}
+
+// respondOAuthError answers the token endpoint with an error as RFC 6749
+// section 5.2 lays it out.
+func respondOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{code, description})
+}
+
+// authenticateClient identifies the client calling the token endpoint, by
+// HTTP Basic auth or client_id and client_secret in the form. Public
+// clients only give their client_id.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OAuthClient, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 has both parts form-encoded.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	var c database.OAuthClient
+	err := cfg.db.View(func(tx database.Tx) error {
+		var err error
+		c, err = tx.GetOAuthClient(clientID)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return database.OAuthClient{}, errInvalidClient
+	}
+	if err != nil {
+		return database.OAuthClient{}, err
+	}
+	if c.SecretHash == "" && secret == "" {
+		return c, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOAuthSecret(secret)), []byte(c.SecretHash)) != 1 {
+		return database.OAuthClient{}, errInvalidClient
+	}
+	return c, nil
+}
+
+// verifyCodeVerifier checks a PKCE code_verifier against the S256
+// code_challenge it was sent with.
+func verifyCodeVerifier(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// handlerOAuthToken is the token endpoint. It exchanges authorization codes
+// and refresh tokens for access tokens carrying the scopes the user granted,
+// narrowed to those the user still has. Refresh tokens rotate as they do
+// for first-party logins and only work for the client they were issued to.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondOAuthError(w, http.StatusBadRequest, "invalid_request", "Error decoding parameters.")
+		return
+	}
+	client, err := cfg.authenticateClient(r)
+	if errors.Is(err, errInvalidClient) {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		respondOAuthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+	if err != nil {
+		respondOAuthError(w, http.StatusInternalServerError, "server_error", "Unable to look up client.")
+		return
+	}
+
+	var user database.User
+	var grant database.RefreshToken
+	var refreshToken string
+	reused := false
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		err = cfg.db.Update(func(tx database.Tx) error {
+			code, err := tx.GetOAuthCode(hashOAuthSecret(r.PostForm.Get("code")))
+			if errors.Is(err, database.ErrNotFound) {
+				return errInvalidGrant
+			}
+			if err != nil {
+				return err
+			}
+			if code.ClientID != client.ClientID {
+				return errInvalidGrant
+			}
+			// A code used twice was intercepted; revoke what the first use
+			// got (RFC 6749 section 4.1.2).
+			if code.UsedAt != 0 {
+				reused = true
+				return tx.RevokeRefreshTokenFamily(code.FamilyID)
+			}
+			if code.ExpiresAt <= time.Now().UTC().Unix() {
+				return errInvalidGrant
+			}
+			// A redirect_uri named in the authorization request has to be
+			// named again, identically (RFC 6749 section 4.1.3).
+			if code.RedirectURI != "" && r.PostForm.Get("redirect_uri") != code.RedirectURI {
+				return errInvalidGrant
+			}
+			if !verifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+				return errInvalidGrant
+			}
+			user, err = tx.GetUserByID(code.UserID)
+			if err != nil {
+				return err
+			}
+			refreshToken, grant, err = issueRefreshToken(tx, database.RefreshToken{
+				UserID:   code.UserID,
+				ClientID: client.ClientID,
+				Scopes:   code.Scopes,
+			}, r)
+			if err != nil {
+				return err
+			}
+			code.UsedAt = time.Now().UTC().Unix()
+			code.FamilyID = grant.FamilyID
+			return tx.UpdateOAuthCode(code)
+		})
+	case "refresh_token":
+		err = cfg.db.Update(func(tx database.Tx) error {
+			var err error
+			refreshToken, grant, reused, err = rotateRefreshToken(tx, r.PostForm.Get("refresh_token"), client.ClientID, r)
+			if err != nil || reused {
+				return err
+			}
+			user, err = tx.GetUserByID(grant.UserID)
+			return err
+		})
+		if errors.Is(err, errInvalidRefreshToken) {
+			err = errInvalidGrant
+		}
+	default:
+		respondOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token.")
+		return
+	}
+	if reused && err == nil {
+		log.Printf("OAuth grant reuse detected for client %s from %s, session revoked", client.ClientID, clientIP(r))
+		err = errInvalidGrant
+	}
+	if errors.Is(err, errInvalidGrant) || errors.Is(err, database.ErrNotFound) {
+		respondOAuthError(w, http.StatusBadRequest, "invalid_grant", errInvalidGrant.Error())
+		return
+	}
+	if err != nil {
+		respondOAuthError(w, http.StatusInternalServerError, "server_error", "Unable to issue tokens.")
+		return
+	}
+
+	scopes := grantedScopes(user, grant.Scopes)
+	accessToken, err := cfg.signAccessToken(user, scopes, client.ClientID, accessTokenTTL)
+	if err != nil {
+		respondOAuthError(w, http.StatusInternalServerError, "server_error", "Unable to issue tokens.")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{accessToken, "Bearer", int(accessTokenTTL / time.Second), refreshToken, strings.Join(scopes, " ")})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestOAuthTokenAuthorizationCode(t *testing.T) {
+	const redirectURI = "https://app.example.com/callback"
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name string
+		// codeRedirectURI is the redirect_uri the authorization request
+		// named.
+		codeRedirectURI string
+		form            url.Values
+		want            int
+	}{
+		{"redirect_uri repeated", redirectURI, url.Values{"code_verifier": {verifier}, "redirect_uri": {redirectURI}}, http.StatusOK},
+		{"redirect_uri never named", "", url.Values{"code_verifier": {verifier}}, http.StatusOK},
+		{"redirect_uri missing", redirectURI, url.Values{"code_verifier": {verifier}}, http.StatusBadRequest},
+		{"redirect_uri mismatched", redirectURI, url.Values{"code_verifier": {verifier}, "redirect_uri": {redirectURI + "/other"}}, http.StatusBadRequest},
+		{"code_verifier wrong", redirectURI, url.Values{"code_verifier": {strings.Repeat("w", 43)}, "redirect_uri": {redirectURI}}, http.StatusBadRequest},
+		{"code_verifier missing", redirectURI, url.Values{"redirect_uri": {redirectURI}}, http.StatusBadRequest},
+		{"code_verifier is the challenge", redirectURI, url.Values{"code_verifier": {challenge}, "redirect_uri": {redirectURI}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+			err := cfg.db.Update(func(tx database.Tx) error {
+				_, err := tx.CreateOAuthClient(database.OAuthClient{
+					ClientID:     "app",
+					OwnerID:      user.ID,
+					Name:         "App",
+					RedirectURIs: []string{redirectURI},
+				})
+				if err != nil {
+					return err
+				}
+				now := time.Now().UTC()
+				_, err = tx.CreateOAuthCode(database.OAuthCode{
+					CodeHash:      hashOAuthSecret("code"),
+					ClientID:      "app",
+					UserID:        user.ID,
+					RedirectURI:   tt.codeRedirectURI,
+					Scopes:        []string{ScopeChirpsWrite},
+					CodeChallenge: challenge,
+					CreatedAt:     now.Unix(),
+					ExpiresAt:     now.Add(time.Minute).Unix(),
+				})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			form := url.Values{
+				"grant_type": {"authorization_code"},
+				"client_id":  {"app"},
+				"code":       {"code"},
+			}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+			r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			cfg.handlerOAuthToken(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body.String(), tt.want)
+			}
+			var resp struct {
+				Error       string `json:"error"`
+				AccessToken string `json:"access_token"`
+			}
+			decodeResponse(t, w, &resp)
+			if tt.want == http.StatusOK && resp.AccessToken == "" {
+				t.Error("no access token")
+			}
+			if tt.want != http.StatusOK && resp.Error != "invalid_grant" {
+				t.Errorf("error is %q, want invalid_grant", resp.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/oidc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// While a user signs in at a provider, what the callback needs to check
+// their return is kept in a cookie holding a JWT with its own audience,
+// so Chirpy keeps no state between the two requests.
+const (
+	oidcStateAudience = "chirpy-oidc-state"
+	oidcStateCookie   = "chirpy_oidc"
+	oidcStateTTL      = 10 * time.Minute
+)
+
+type oidcStateClaims struct {
+	jwt.RegisteredClaims
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcProvidersFromEnv reads the providers named in OIDC_PROVIDERS, a
+// comma separated list. Each one NAME is configured by OIDC_NAME_ISSUER,
+// OIDC_NAME_CLIENT_ID and OIDC_NAME_CLIENT_SECRET. Providers send users
+// back to apiURL/api/oidc/name/callback, which has to be registered with
+// them.
+func oidcProvidersFromEnv(apiURL string) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  apiURL + "/api/oidc/" + name + "/callback",
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		providers[name] = oidc.NewProvider(cfg)
+	}
+	return providers, nil
+}
+
+// providerName returns the name Chirpy knows the provider with issuer by,
+// or the issuer itself if the provider is no longer configured.
+func (cfg *apiConfig) providerName(issuer string) string {
+	for name, p := range cfg.oidcProviders {
+		if p.Issuer == issuer {
+			return name
+		}
+	}
+	return issuer
+}
+
+func (cfg *apiConfig) issueOIDCState(claims oidcStateClaims) (string, error) {
+	now := time.Now().UTC()
+	claims.Issuer = jwtIssuer
+	claims.Audience = jwt.ClaimStrings{oidcStateAudience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(oidcStateTTL))
+	return cfg.keys.Sign(claims)
+}
+
+func (cfg *apiConfig) parseOIDCState(tokenString string) (*oidcStateClaims, error) {
+	token, err := cfg.keys.Parse(tokenString, &oidcStateClaims{})
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(*oidcStateClaims)
+	if claims.ExpiresAt == nil || !claims.VerifyIssuer(jwtIssuer, true) || !claims.VerifyAudience(oidcStateAudience, true) {
+		return nil, fmt.Errorf("Invalid sign-in state")
+	}
+	return claims, nil
+}
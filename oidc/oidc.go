@@ -0,0 +1,268 @@
+// Package oidc signs users in with external OpenID Connect providers using
+// the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bigbabyjack/chirpy/auth"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwksRefreshInterval is how often an unknown kid may make a provider's
+// keys be fetched again, so a flood of forged tokens can't hammer it.
+const jwksRefreshInterval = time.Minute
+
+// signingMethods are the algorithms ID tokens are accepted with. Shared
+// secret and unsigned tokens never are.
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Config describes one provider. Issuer is where its discovery document
+// lives, under /.well-known/openid-configuration. RedirectURL is Chirpy's
+// callback the provider sends users back to.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// metadata is the part of a discovery document Chirpy uses.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider. Its discovery document and keys
+// are fetched on first use and cached.
+type Provider struct {
+	Config
+	client *http.Client
+
+	mux         *sync.Mutex
+	meta        *metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewProvider(cfg Config) *Provider {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Provider{
+		Config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		mux:    &sync.Mutex{},
+	}
+}
+
+// IDToken is the verified identity a provider vouched for.
+type IDToken struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the provider's metadata, fetching it the first time.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	meta := &metadata{}
+	err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", meta)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to discover %s: %w", p.Name, err)
+	}
+	// OpenID Connect Discovery section 4.3.
+	if strings.TrimSuffix(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("Discovery document of %s names issuer %q", p.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("Discovery document of %s is missing endpoints", p.Name)
+	}
+	p.meta = meta
+	return meta, nil
+}
+
+// AuthCodeURL returns where to send the user to sign in. state and nonce
+// should be random and remembered for the callback; codeChallenge is the
+// S256 PKCE challenge.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", "openid email")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades an authorization code for the user's verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*IDToken, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode token response of %s: %w", p.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s refused the code: %s %s", p.Name, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%s returned no ID token", p.Name)
+	}
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+// Verify checks an ID token as OpenID Connect Core section 3.1.3.7 asks:
+// signed by one of the provider's keys, issued by it, for this client,
+// not expired, and carrying the nonce the sign-in was started with.
+func (p *Provider) Verify(ctx context.Context, raw string, nonce string) (*IDToken, error) {
+	token, err := jwt.ParseWithClaims(raw, &IDToken{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}, jwt.WithValidMethods(signingMethods))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %w", err)
+	}
+	claims := token.Claims.(*IDToken)
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, errors.New("ID token has no expiry or issue time")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("ID token issuer %q is not %s", claims.Issuer, p.Name)
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("ID token is not meant for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("ID token was issued to another party")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// key returns the provider's key kid, fetching the keys again if it is
+// unknown, as happens after the provider rotates them. An empty kid is
+// allowed when the provider has a single key.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("Unknown key id %q", kid)
+	}
+	jwks := auth.JWKS{}
+	err = p.getJSON(ctx, meta.JWKSURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch keys of %s: %w", p.Name, err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown key id %q", kid)
+}
+
+// lookupKey finds kid in the cached keys. The caller holds p.mux.
+func (p *Provider) lookupKey(kid string) crypto.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+// Scopes are the individual permissions an access token can carry.
+const (
+	ScopeChirpsWrite    = "chirps:write"
+	ScopeChirpsModerate = "chirps:moderate"
+	ScopeUsersAdmin     = "users:admin"
+	ScopeAdminMetrics   = "admin:metrics"
+	ScopeAdminReset     = "admin:reset"
+	ScopeAdminLockouts  = "admin:lockouts"
+)
+
+// roleScopes is what each role may do. A user can be granted extra scopes
+// on top of their role's.
+var roleScopes = map[string][]string{
+	database.RoleUser: {
+		ScopeChirpsWrite,
+	},
+	database.RoleModerator: {
+		ScopeChirpsWrite,
+		ScopeChirpsModerate,
+	},
+	database.RoleAdmin: {
+		ScopeChirpsWrite,
+		ScopeChirpsModerate,
+		ScopeUsersAdmin,
+		ScopeAdminMetrics,
+		ScopeAdminReset,
+		ScopeAdminLockouts,
+	},
+}
+
+func roleOf(user database.User) string {
+	if user.Role == "" {
+		return database.RoleUser
+	}
+	return user.Role
+}
+
+func validScope(scope string) bool {
+	return slices.Contains(roleScopes[database.RoleAdmin], scope)
+}
+
+// scopesFor returns every scope user has, through their role or directly.
+func scopesFor(user database.User) []string {
+	scopes := slices.Clone(roleScopes[roleOf(user)])
+	for _, s := range user.Scopes {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// grantedScopes returns the scopes in requested that user has. Credentials
+// limited to a set of scopes, such as API keys and tokens of third-party
+// apps, only ever grant these, so taking a role away from a user takes it
+// away from their credentials too.
+func grantedScopes(user database.User, requested []string) []string {
+	userScopes := scopesFor(user)
+	granted := []string{}
+	for _, s := range requested {
+		if slices.Contains(userScopes, s) {
+			granted = append(granted, s)
+		}
+	}
+	return granted
+}
+
+// HasScope reports whether the caller's token grants scope.
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// requireScope only lets requests whose access token grants scope through
+// to next.
+func (cfg *apiConfig) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFromContext(r.Context())
+		if !p.HasScope(scope) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Missing scope %s", scope))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// handlerUpdateUserRole lets an admin change a user's role and extra scopes.
+// The change applies to access tokens issued from then on.
+func (cfg *apiConfig) handlerUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid userID %v", r.PathValue("userID")))
+		return
+	}
+	type parameters struct {
+		Role   string   `json:"role"`
+		Scopes []string `json:"scopes"`
+	}
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error decoding parameters.")
+		return
+	}
+	if _, ok := roleScopes[params.Role]; !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown role %q", params.Role))
+		return
+	}
+	for _, s := range params.Scopes {
+		if !validScope(s) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", s))
+			return
+		}
+	}
+
+	var user database.User
+	err = cfg.db.Update(func(tx database.Tx) error {
+		user, err = tx.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		user.Role = params.Role
+		user.Scopes = params.Scopes
+		_, err = tx.UpdateUser(userID, user)
+		return err
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("User with ID %v not found", userID))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update role.")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// promoteAdmin makes the user with the given email an admin. It is how the
+// first admin gets created, and only runs when the operator asks for it
+// with -promote-admin, so signing up with a known address is not enough.
+// The user must also have verified the address, so an account someone else
+// registered under it before its owner did is refused.
+func promoteAdmin(db database.Store, email string) error {
+	return db.Update(func(tx database.Tx) error {
+		user, err := tx.GetUser(normalizeEmail(email))
+		if err != nil {
+			return err
+		}
+		if !user.EmailVerified {
+			return fmt.Errorf("%s has not verified their email address", email)
+		}
+		if user.Role == database.RoleAdmin {
+			return nil
+		}
+		user.Role = database.RoleAdmin
+		_, err = tx.UpdateUser(user.ID, user)
+		return err
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bigbabyjack/chirpy/database"
+)
+
+func TestPromoteAdmin(t *testing.T) {
+	tests := []struct {
+		name      string
+		email     string
+		verified  bool
+		wantErr   bool
+		wantAdmin bool
+	}{
+		{"verified", "a@example.com", true, false, true},
+		{"unverified", "a@example.com", false, true, false},
+		{"unknown", "b@example.com", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := createTestUser(t, cfg, "a@example.com", "chirp-chirp-42")
+			user.EmailVerified = tt.verified
+			err := cfg.db.Update(func(tx database.Tx) error {
+				_, err := tx.UpdateUser(user.ID, user)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = promoteAdmin(cfg.db, tt.email)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("promoteAdmin returned %v, want error = %v", err, tt.wantErr)
+			}
+			err = cfg.db.View(func(tx database.Tx) error {
+				user, err = tx.GetUserByID(user.ID)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := user.Role == database.RoleAdmin; got != tt.wantAdmin {
+				t.Errorf("user is admin = %v, want %v", got, tt.wantAdmin)
+			}
+		})
+	}
+}